@@ -12,7 +12,7 @@ async function fetchRanking() {
       row.innerHTML = `
         <td>${index + 1}</td>
         <td>${player.Username}</td>
-        <td>${player.Score}</td>
+        <td>${player.Rating}${player.Provisional ? "?" : ""}</td>
       `;
 
       tbody.appendChild(row);
@@ -18,6 +18,7 @@ let board = [];       // 6x7 matrix
 let gameEnded = false; // Track if game ended normally
 let intentionalClose = false; // Track if we're closing on purpose
 let gameTimer = 0; // Timer in seconds
+let playerNames = ['', '']; // Names shown above the board
 
 // --- Persistent Logging Setup ---
 function persistLog(msg) {
@@ -139,13 +140,20 @@ function handleMessage(data) {
             myTurn = data.starting_player === playerNumber;
 
             console.log(`[GAME_START] You are Player ${playerNumber}, starting turn: ${myTurn}`);
+            playerNames = [data.player1_name, data.player2_name];
             initializeBoard(board, data.player1_name, data.player2_name);
             updateTurnMessage();
             break;
 
         case 'MOVE':
             console.log(`[MOVE] Player ${data.player} placed in column ${data.col} at row ${data.row}`);
-            applyMove(data.col, data.player, data.row);  // Pass row from server
+            if (data.board) {
+                // Fog of war: the server sends our own view of the board
+                initializeBoard(data.board, playerNames[0], playerNames[1]);
+            } else {
+                // In team games discs take their team's colour, not the seat's
+                applyMove(data.col, data.team || data.player, data.row);  // Pass row from server
+            }
             myTurn = (data.next_turn === playerNumber);
             updateTurnMessage();
             break;
@@ -162,6 +170,10 @@ function handleMessage(data) {
             console.log(`[GAME_OVER] gameArea is hidden?`, gameArea.classList.contains('hidden'));
             console.log(`[GAME_OVER] gameArea display style:`, window.getComputedStyle(gameArea).display);
             
+            if (data.board) {
+                // Reveal the whole board, including cells hidden by fog of war
+                initializeBoard(data.board, playerNames[0], playerNames[1]);
+            }
             gameInfo.innerHTML = `<strong>${data.message}</strong><br/>`;
 
             const returnBtn = document.createElement('button');
@@ -250,7 +262,7 @@ function initializeBoard(serverBoard, player1Name, player2Name) {
 
             if (board[row][col] !== 0) {
                 const disc = document.createElement('div');
-                disc.classList.add('disc', board[row][col] === 1 ? 'player1' : 'player2');
+                disc.classList.add('disc', discClass(board[row][col]));
                 disc.style.transform = "translateY(0)";
                 cell.appendChild(disc);
             }
@@ -277,14 +289,15 @@ function handleCellClick(col) {
 }
 
 // --- Apply Move from Server ---
-function applyMove(col, player, row) {  // Add row parameter
-    board[row][col] = player;
+// piece is what the server stores on the board: the seat, or the team in team games
+function applyMove(col, piece, row) {  // Add row parameter
+    board[row][col] = piece;
 
     const cellIndex = row * board[0].length + col;
     const cell = gameGrid.children[cellIndex];
 
     const disc = document.createElement('div');
-    disc.classList.add('disc', player === 1 ? 'player1' : 'player2');
+    disc.classList.add('disc', discClass(piece));
     cell.appendChild(disc);
 
     setTimeout(() => {
@@ -292,10 +305,19 @@ function applyMove(col, player, row) {  // Add row parameter
     }, 50);
 }
 
+// --- CSS class for a cell value (-1 is a neutral stone, -2 is hidden by fog) ---
+function discClass(value) {
+    if (value === -1) return 'blocked';
+    if (value === -2) return 'fogged';
+    return value >= 1 && value <= 4 ? `player${value}` : 'player2';
+}
+
 // --- Find lowest empty row in a column ---
+// A cell hidden by fog (-2) may be empty, so the server decides whether
+// the column is full
 function findAvailableRow(col) {
     for (let row = board.length - 1; row >= 0; row--) {
-        if (board[row][col] === 0) return row;
+        if (board[row][col] === 0 || board[row][col] === -2) return row;
     }
     return -1;
 }
@@ -11,6 +11,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -41,7 +42,11 @@ func main() {
 	createRankingsTableSQL := `
 	CREATE TABLE IF NOT EXISTS rankings (
 		username TEXT PRIMARY KEY,
-		score INTEGER NOT NULL DEFAULT 0
+		rating REAL NOT NULL DEFAULT 1500,
+		deviation REAL NOT NULL DEFAULT 350,
+		volatility REAL NOT NULL DEFAULT 0.06,
+		rated_games INTEGER NOT NULL DEFAULT 0,
+		rated_at INTEGER
 	);`
 	_, err = db.Exec(createTableSQL)
 	if err != nil {
@@ -53,11 +58,18 @@ func main() {
 	if err != nil {
 		log.Fatalf("Failed to create rankings table: %v", err)
 	}
+	addColumn(db, "rankings", "rating", "REAL NOT NULL DEFAULT 1500")
+	addColumn(db, "rankings", "deviation", "REAL NOT NULL DEFAULT 350")
+	addColumn(db, "rankings", "volatility", "REAL NOT NULL DEFAULT 0.06")
+	addColumn(db, "rankings", "rated_games", "INTEGER NOT NULL DEFAULT 0")
+	addColumn(db, "rankings", "rated_at", "INTEGER")
+	// The old win count gave way to the Glicko-2 rating
+	dropColumn(db, "rankings", "score")
 	fmt.Println("Rankings table created or already exists")
 
 	_, err = db.Exec(`
-    INSERT INTO rankings (username, score)
-    SELECT username, 0
+    INSERT INTO rankings (username)
+    SELECT username
     FROM users
     WHERE username NOT IN (SELECT username FROM rankings)
 `)
@@ -67,6 +79,7 @@ func main() {
 	fmt.Println("Rankings table backfilled for existing users")
 	// Initialize matchmaking with DB
 	matchmaking.InitRankingDB(db)
+	matchmaking.Configure(cfg)
 
 	createGamesTableSQL := `
 	CREATE TABLE IF NOT EXISTS games (
@@ -75,19 +88,203 @@ func main() {
 		player2 TEXT NOT NULL,
 		winner TEXT,
 		moves TEXT,
+		players TEXT,
+		placements TEXT,
+		teams TEXT,
+		layout TEXT,
+		start_position TEXT,
+		state TEXT,
+		result TEXT,
+		reason TEXT,
+		time_control TEXT,
+		clocks TEXT,
+		series_id INTEGER REFERENCES series(id),
+		rated INTEGER NOT NULL DEFAULT 1,
 		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 	);`
 	_, err = db.Exec(createGamesTableSQL)
 	if err != nil {
 		log.Fatalf("Failed to create games table: %v", err)
 	}
+	// Databases created by older versions of the server lack these columns
+	addColumn(db, "games", "players", "TEXT")
+	addColumn(db, "games", "placements", "TEXT")
+	addColumn(db, "games", "teams", "TEXT")
+	addColumn(db, "games", "layout", "TEXT")
+	addColumn(db, "games", "start_position", "TEXT")
+	addColumn(db, "games", "state", "TEXT")
+	addColumn(db, "games", "result", "TEXT")
+	addColumn(db, "games", "reason", "TEXT")
+	addColumn(db, "games", "time_control", "TEXT")
+	addColumn(db, "games", "clocks", "TEXT")
+	addColumn(db, "games", "series_id", "INTEGER REFERENCES series(id)")
+	addColumn(db, "games", "rated", "INTEGER NOT NULL DEFAULT 1")
 	fmt.Println("Games table created or already exists")
 
+	createSeriesTableSQL := `
+	CREATE TABLE IF NOT EXISTS series (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		player1 TEXT NOT NULL,
+		player2 TEXT NOT NULL,
+		best_of INTEGER NOT NULL,
+		games INTEGER NOT NULL DEFAULT 0,
+		score1 REAL NOT NULL DEFAULT 0,
+		score2 REAL NOT NULL DEFAULT 0,
+		winner TEXT,
+		status TEXT NOT NULL DEFAULT 'active',
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		finished_at TIMESTAMP
+	);`
+	_, err = db.Exec(createSeriesTableSQL)
+	if err != nil {
+		log.Fatalf("Failed to create series table: %v", err)
+	}
+	fmt.Println("Series table created or already exists")
+
+	createAbortStatsTableSQL := `
+	CREATE TABLE IF NOT EXISTS abort_stats (
+		username TEXT PRIMARY KEY,
+		games INTEGER NOT NULL DEFAULT 0,
+		aborts INTEGER NOT NULL DEFAULT 0
+	);`
+	_, err = db.Exec(createAbortStatsTableSQL)
+	if err != nil {
+		log.Fatalf("Failed to create abort_stats table: %v", err)
+	}
+	fmt.Println("Abort stats table created or already exists")
+
+	createAbandonmentsTableSQL := `
+	CREATE TABLE IF NOT EXISTS abandonments (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		username TEXT NOT NULL,
+		game_id TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS abandonments_username ON abandonments (username, created_at);`
+	_, err = db.Exec(createAbandonmentsTableSQL)
+	if err != nil {
+		log.Fatalf("Failed to create abandonments table: %v", err)
+	}
+	fmt.Println("Abandonments table created or already exists")
+
+	createRatingChangesTableSQL := `
+	CREATE TABLE IF NOT EXISTS rating_changes (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		game_id INTEGER NOT NULL REFERENCES games(id),
+		username TEXT NOT NULL,
+		rating_before REAL NOT NULL,
+		deviation_before REAL NOT NULL,
+		volatility_before REAL NOT NULL,
+		rating_after REAL NOT NULL,
+		deviation_after REAL NOT NULL,
+		volatility_after REAL NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS rating_changes_username ON rating_changes (username, id);`
+	_, err = db.Exec(createRatingChangesTableSQL)
+	if err != nil {
+		log.Fatalf("Failed to create rating_changes table: %v", err)
+	}
+	fmt.Println("Rating changes table created or already exists")
+
+	createTournamentsTableSQL := `
+	CREATE TABLE IF NOT EXISTS tournaments (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		format TEXT NOT NULL,
+		settings TEXT NOT NULL,
+		rounds INTEGER NOT NULL DEFAULT 0,
+		round INTEGER NOT NULL DEFAULT 0,
+		status TEXT NOT NULL DEFAULT 'registering',
+		created_by TEXT NOT NULL,
+		starts_at INTEGER NOT NULL,
+		winner TEXT,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		finished_at TIMESTAMP
+	);
+	CREATE TABLE IF NOT EXISTS tournament_players (
+		tournament_id INTEGER NOT NULL REFERENCES tournaments(id),
+		username TEXT NOT NULL,
+		rating REAL NOT NULL,
+		withdrawn INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (tournament_id, username)
+	);
+	CREATE TABLE IF NOT EXISTS tournament_pairings (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		tournament_id INTEGER NOT NULL REFERENCES tournaments(id),
+		round INTEGER NOT NULL,
+		board INTEGER NOT NULL,
+		player1 TEXT NOT NULL,
+		player2 TEXT,
+		result TEXT,
+		forfeit INTEGER NOT NULL DEFAULT 0,
+		games TEXT,
+		live_game TEXT
+	);
+	CREATE INDEX IF NOT EXISTS tournament_pairings_round ON tournament_pairings (tournament_id, round, board);`
+	_, err = db.Exec(createTournamentsTableSQL)
+	if err != nil {
+		log.Fatalf("Failed to create tournament tables: %v", err)
+	}
+	fmt.Println("Tournament tables created or already exist")
+	matchmaking.ResumeTournaments()
+
+	createArenasTableSQL := `
+	CREATE TABLE IF NOT EXISTS arenas (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		settings TEXT NOT NULL,
+		minutes INTEGER NOT NULL,
+		status TEXT NOT NULL DEFAULT 'upcoming',
+		created_by TEXT NOT NULL,
+		starts_at INTEGER NOT NULL,
+		winner TEXT,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE TABLE IF NOT EXISTS arena_scores (
+		arena_id INTEGER NOT NULL REFERENCES arenas(id),
+		username TEXT NOT NULL,
+		score INTEGER NOT NULL DEFAULT 0,
+		games INTEGER NOT NULL DEFAULT 0,
+		wins INTEGER NOT NULL DEFAULT 0,
+		draws INTEGER NOT NULL DEFAULT 0,
+		losses INTEGER NOT NULL DEFAULT 0,
+		streak INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (arena_id, username)
+	);`
+	_, err = db.Exec(createArenasTableSQL)
+	if err != nil {
+		log.Fatalf("Failed to create arena tables: %v", err)
+	}
+	fmt.Println("Arena tables created or already exist")
+	matchmaking.ResumeArenas()
+
 	router := http.NewServeMux()
-	router.HandleFunc("/api/signup", users.SignupHandler(db))     // api for signup
-	router.HandleFunc("/api/login", users.LoginHandler(db))       // api for login
-	router.HandleFunc("/ws/game", matchmaking.HandleGame)         // WebSocket endpoint for games
-	router.HandleFunc("/api/rankings", matchmaking.HandleRanking) // api for rankings
+	router.HandleFunc("/api/signup", users.SignupHandler(db))                  // api for signup
+	router.HandleFunc("/api/login", users.LoginHandler(db))                    // api for login
+	router.HandleFunc("/ws/game", matchmaking.HandleGame)                      // WebSocket endpoint for games
+	router.HandleFunc("/api/rankings", matchmaking.HandleRanking)              // api for rankings
+	router.HandleFunc("/api/games", matchmaking.HandleGames)                   // api for finished games
+	router.HandleFunc("/api/games/live", matchmaking.HandleLiveGames)          // api for games in progress
+	router.HandleFunc("/api/series", matchmaking.HandleSeries)                 // api for best-of-N series
+	router.HandleFunc("/api/series/rankings", matchmaking.HandleSeriesRanking) // api for series rankings
+	router.HandleFunc("/api/aborts", matchmaking.HandleAbortStats)             // api for abort rates
+	router.HandleFunc("/api/ratings", matchmaking.HandleRatingHistory)         // api for rating history
+	router.HandleFunc("/api/queues", matchmaking.HandleQueues)                 // api for matchmaking queues
+	router.HandleFunc("/api/challenges", matchmaking.HandleChallenges)         // api for challenges and private rooms
+	router.HandleFunc("/api/challenges/accept", matchmaking.HandleChallengeAnswer(true))
+	router.HandleFunc("/api/challenges/decline", matchmaking.HandleChallengeAnswer(false))
+	router.HandleFunc("/api/lobby", matchmaking.HandleLobby)             // api for open seeks
+	router.HandleFunc("/ws/lobby", matchmaking.HandleLobbySocket)        // WebSocket endpoint streaming the lobby
+	router.HandleFunc("/api/tournaments", matchmaking.HandleTournaments) // api for tournaments
+	router.HandleFunc("/api/tournaments/join", matchmaking.HandleTournamentEntry(true))
+	router.HandleFunc("/api/tournaments/leave", matchmaking.HandleTournamentEntry(false))
+	router.HandleFunc("/api/tournaments/standings", matchmaking.HandleTournamentStandings) // api for tournament standings
+	router.HandleFunc("/api/tournaments/bracket", matchmaking.HandleTournamentBracket)     // api for tournament pairings by round
+	router.HandleFunc("/api/arenas", matchmaking.HandleArenas)                             // api for arenas
+	router.HandleFunc("/api/arenas/leaderboard", matchmaking.HandleArenaLeaderboard)       // api for arena leaderboards
+	router.HandleFunc("/ws/watch", matchmaking.HandleWatch)                                // WebSocket endpoint for spectators
+	router.HandleFunc("/ws/tv", matchmaking.HandleTV)                                      // WebSocket endpoint following the featured game
 
 	fmt.Println("Router setup complete")
 
@@ -126,3 +323,22 @@ func main() {
 	}
 	fmt.Println("Server stopped")
 }
+
+// addColumn adds a column to a table created by an older version of the
+// server. SQLite has no ADD COLUMN IF NOT EXISTS, so the duplicate column
+// error is what tells us the table is already up to date.
+func addColumn(db *sql.DB, table, column, definition string) {
+	_, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+		log.Fatalf("Failed to add column %s.%s: %v", table, column, err)
+	}
+}
+
+// dropColumn removes a column that is no longer used from databases created
+// by older versions of the server.
+func dropColumn(db *sql.DB, table, column string) {
+	_, err := db.Exec(fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", table, column))
+	if err != nil && !strings.Contains(err.Error(), "no such column") {
+		log.Fatalf("Failed to drop column %s.%s: %v", table, column, err)
+	}
+}
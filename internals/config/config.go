@@ -24,10 +24,18 @@ type Config struct {
 	} `yaml:"kafka"`
 
 	Game struct {
-		MatchmakingTimeoutSeconds int `yaml:"matchmaking_timeout_seconds"`
-		ReconnectTimeoutSeconds   int `yaml:"reconnect_timeout_seconds"`
-		BoardRows                 int `yaml:"board_rows"`
-		BoardColumns              int `yaml:"board_columns"`
+		MatchmakingTimeoutSeconds int    `yaml:"matchmaking_timeout_seconds"`
+		ReconnectTimeoutSeconds   int    `yaml:"reconnect_timeout_seconds"`
+		BoardRows                 int    `yaml:"board_rows"`
+		BoardColumns              int    `yaml:"board_columns"`
+		DefaultTimeControl        string `yaml:"default_time_control"`
+		MoveTimeoutSeconds        int    `yaml:"move_timeout_seconds"`
+		MoveWarningSeconds        int    `yaml:"move_warning_seconds"`
+		RatedTakebacks            int    `yaml:"rated_takebacks"`
+		AbortWindowSeconds        int    `yaml:"abort_window_seconds"`
+		RatingPeriodDays          int    `yaml:"rating_period_days"`
+		ChallengeExpirySeconds    int    `yaml:"challenge_expiry_seconds"`
+		TournamentForfeitSeconds  int    `yaml:"tournament_forfeit_seconds"`
 	} `yaml:"game"`
 }
 
@@ -2,11 +2,7 @@ package game
 
 import (
 	"math"
-)
-
-const (
-	HumanPlayer = 1
-	BotPlayer   = 2
+	"math/rand"
 )
 
 // Get valid columns where a move can be played
@@ -28,13 +24,22 @@ func copyGame(g *Game) *Game {
 		copy(newBoard[i], g.Board[i])
 	}
 	return &Game{
-		ID:      g.ID,
-		Board:   newBoard,
-		Player1: g.Player1,
-		Player2: g.Player2,
-		Turn:    g.Turn,
-		Over:    g.Over,
-		Moves:   append([]string{}, g.Moves...),
+		ID:         g.ID,
+		Board:      newBoard,
+		Rows:       g.Rows,
+		Cols:       g.Cols,
+		Players:    g.Players,
+		Teams:      g.Teams,
+		Turn:       g.Turn,
+		Places:     append([]int{}, g.Places...),
+		State:      g.State,
+		Moves:      append([]string{}, g.Moves...),
+		LayoutName: g.LayoutName,
+		Obstacles:  g.Obstacles,
+		Fog:        g.Fog,
+		nextPlace:  g.nextPlace,
+		lastPlace:  g.lastPlace,
+		setupMoves: g.setupMoves,
 	}
 }
 
@@ -92,19 +97,26 @@ func scorePosition(board [][]int, piece int) int {
 // Evaluate 4-slot window
 func evaluateWindow(window []int, piece int) int {
 	score := 0
-	opp := HumanPlayer
-	if piece == HumanPlayer {
-		opp = BotPlayer
-	}
 
+	// Any other disc counts as an opponent's, but a window only threatens
+	// us when all of those discs belong to the same opponent.
 	countPiece, countOpp, countEmpty := 0, 0, 0
+	opp := 0
+	mixed := false
 	for _, v := range window {
+		if v == Blocked {
+			return 0 // an obstacle means nobody can ever complete this window
+		}
 		if v == piece {
 			countPiece++
-		} else if v == opp {
-			countOpp++
-		} else {
+		} else if v == 0 {
 			countEmpty++
+		} else {
+			countOpp++
+			if opp != 0 && opp != v {
+				mixed = true
+			}
+			opp = v
 		}
 	}
 
@@ -116,15 +128,32 @@ func evaluateWindow(window []int, piece int) int {
 		score += 2
 	}
 
-	if countOpp == 3 && countEmpty == 1 {
+	if countOpp == 3 && countEmpty == 1 && !mixed {
 		score -= 4
 	}
 
 	return score
 }
 
-// Minimax with alpha-beta pruning
-func minimax(g *Game, depth int, alpha, beta float64, maximizingPlayer bool) (int, float64) {
+// evaluate scores the board from the point of view of seat me, against
+// every other side still in the game. Teammates count as one side.
+func evaluate(g *Game, me int) float64 {
+	mine := g.Piece(me)
+	score := scorePosition(g.Board, mine)
+	seen := map[int]bool{mine: true}
+	for _, seat := range g.ActiveSeats() {
+		piece := g.Piece(seat)
+		if !seen[piece] {
+			seen[piece] = true
+			score -= scorePosition(g.Board, piece)
+		}
+	}
+	return float64(score)
+}
+
+// Minimax with alpha-beta pruning. With more than two seats every other
+// side is assumed to be playing against me, while a teammate plays with me.
+func minimax(g *Game, depth int, alpha, beta float64, me int) (int, float64) {
 	validLocations := getValidLocations(g.Board)
 	isDraw := g.CheckDraw()
 
@@ -133,23 +162,24 @@ func minimax(g *Game, depth int, alpha, beta float64, maximizingPlayer bool) (in
 		if isDraw {
 			return -1, 0 // Neutral score for draw
 		}
-		netScore := float64(scorePosition(g.Board, BotPlayer) - scorePosition(g.Board, HumanPlayer))
-		return -1, netScore
+		return -1, evaluate(g, me)
 	}
 
-	if maximizingPlayer {
+	mover := g.Turn
+	piece := g.Piece(mover)
+	if piece == g.Piece(me) {
 		value := math.Inf(-1)
 		bestCol := validLocations[0]
 		for _, col := range validLocations {
 			temp := copyGame(g)
-			row, _, err := temp.PlaceDisc(BotPlayer, col)
+			row, _, err := temp.PlaceDisc(mover, col)
 			if err != nil {
 				continue
 			}
-			if temp.CheckWin(row, col, BotPlayer) {
+			if temp.CheckWin(row, col, piece) {
 				return col, 10000 // Winning move
 			}
-			_, newScore := minimax(temp, depth-1, alpha, beta, false)
+			_, newScore := minimax(temp, depth-1, alpha, beta, me)
 			if newScore > value {
 				value = newScore
 				bestCol = col
@@ -165,14 +195,14 @@ func minimax(g *Game, depth int, alpha, beta float64, maximizingPlayer bool) (in
 		bestCol := validLocations[0]
 		for _, col := range validLocations {
 			temp := copyGame(g)
-			row, _, err := temp.PlaceDisc(HumanPlayer, col)
+			row, _, err := temp.PlaceDisc(mover, col)
 			if err != nil {
 				continue
 			}
-			if temp.CheckWin(row, col, HumanPlayer) {
+			if temp.CheckWin(row, col, piece) {
 				return col, -10000 // Opponent wins
 			}
-			_, newScore := minimax(temp, depth-1, alpha, beta, true)
+			_, newScore := minimax(temp, depth-1, alpha, beta, me)
 			if newScore < value {
 				value = newScore
 				bestCol = col
@@ -186,8 +216,29 @@ func minimax(g *Game, depth int, alpha, beta float64, maximizingPlayer bool) (in
 	}
 }
 
-// Public function to find best move for bot
+// RandomMove picks any playable column, or -1 if the board is full.
+func RandomMove(g *Game) int {
+	valid := getValidLocations(g.Board)
+	if len(valid) == 0 {
+		return -1
+	}
+	return valid[rand.Intn(len(valid))]
+}
+
+// Public function to find best move for bot. The bot plays for whichever
+// seat is to move.
 func FindBestMove(g *Game, depth int) int {
-	col, _ := minimax(g, depth, math.Inf(-1), math.Inf(1), true)
+	col, _ := minimax(g, depth, math.Inf(-1), math.Inf(1), g.Turn)
 	return col
 }
+
+// AcceptsDraw decides whether a bot playing seat takes a draw offer. It
+// searches the position from its own point of view and accepts unless it
+// thinks it is ahead.
+func AcceptsDraw(g *Game, seat, depth int) bool {
+	if len(getValidLocations(g.Board)) == 0 {
+		return true
+	}
+	_, score := minimax(g, depth, math.Inf(-1), math.Inf(1), seat)
+	return score <= 0
+}
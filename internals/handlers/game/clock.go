@@ -0,0 +1,190 @@
+package game
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ClockMode is how a time control gives time back after each move.
+type ClockMode string
+
+const (
+	SuddenDeath ClockMode = "sudden_death" // no time back
+	Fischer     ClockMode = "fischer"      // a fixed increment after every move
+	Bronstein   ClockMode = "bronstein"    // the time used, up to the delay
+)
+
+// TimeControl describes the time each seat gets.
+type TimeControl struct {
+	Mode      ClockMode
+	Initial   time.Duration
+	Increment time.Duration // the Fischer increment or the Bronstein delay
+}
+
+// ParseTimeControl reads a time control written as "<minutes>" for sudden
+// death, "<minutes>+<seconds>" for a Fischer increment or "<minutes>d<seconds>"
+// for a Bronstein delay, e.g. "5", "3+2" or "5d3".
+func ParseTimeControl(s string) (TimeControl, error) {
+	tc := TimeControl{Mode: SuddenDeath}
+	base, extra := s, ""
+	if i := strings.IndexAny(s, "+d"); i >= 0 {
+		base, extra = s[:i], s[i+1:]
+		tc.Mode = Fischer
+		if s[i] == 'd' {
+			tc.Mode = Bronstein
+		}
+	}
+	// NaN and times too long for a time.Duration are as bad as no time at all
+	minutes, err := strconv.ParseFloat(base, 64)
+	if err != nil || !(minutes > 0) || minutes > float64(math.MaxInt64/int64(time.Minute)) {
+		return tc, fmt.Errorf("bad time control %q", s)
+	}
+	tc.Initial = time.Duration(minutes * float64(time.Minute))
+	if tc.Mode != SuddenDeath {
+		seconds, err := strconv.Atoi(extra)
+		if err != nil || seconds < 0 || int64(seconds) > math.MaxInt64/int64(time.Second) {
+			return tc, fmt.Errorf("bad time control %q", s)
+		}
+		tc.Increment = time.Duration(seconds) * time.Second
+	}
+	return tc, nil
+}
+
+// String writes the time control the way ParseTimeControl reads it.
+func (tc TimeControl) String() string {
+	minutes := strconv.FormatFloat(tc.Initial.Minutes(), 'f', -1, 64)
+	switch tc.Mode {
+	case Fischer:
+		return fmt.Sprintf("%s+%d", minutes, int(tc.Increment.Seconds()))
+	case Bronstein:
+		return fmt.Sprintf("%sd%d", minutes, int(tc.Increment.Seconds()))
+	default:
+		return minutes
+	}
+}
+
+// Clock keeps every seat's remaining time. Only the seat to move has its
+// clock running; the server is the only one who punches it.
+type Clock struct {
+	Control   TimeControl
+	Remaining []time.Duration // per seat, as of the last punch
+	Running   int             // seat whose clock is running, 0 when stopped
+
+	since time.Time // when the running clock was started
+}
+
+// NewClock gives every seat the initial time of the control.
+func NewClock(tc TimeControl, seats int) *Clock {
+	c := &Clock{Control: tc, Remaining: make([]time.Duration, seats)}
+	for i := range c.Remaining {
+		c.Remaining[i] = tc.Initial
+	}
+	return c
+}
+
+// Start runs the given seat's clock from now.
+func (c *Clock) Start(seat int, now time.Time) {
+	c.Running = seat
+	c.since = now
+}
+
+// Punch stops the running clock, charging the seat for the time it used
+// and giving back the increment or delay. It reports whether the seat had
+// already run out of time, in which case nothing is given back.
+func (c *Clock) Punch(now time.Time) bool {
+	if c.Running == 0 {
+		return false
+	}
+	i := c.Running - 1
+	used := now.Sub(c.since)
+	c.Running = 0
+	c.Remaining[i] -= used
+	if c.Remaining[i] <= 0 {
+		c.Remaining[i] = 0
+		return true
+	}
+	switch c.Control.Mode {
+	case Fischer:
+		c.Remaining[i] += c.Control.Increment
+	case Bronstein:
+		if used < c.Control.Increment {
+			c.Remaining[i] += used
+		} else {
+			c.Remaining[i] += c.Control.Increment
+		}
+	}
+	return false
+}
+
+// Stop stops the running clock, charging the seat for the time it used but
+// giving nothing back, as when the game is paused.
+func (c *Clock) Stop(now time.Time) {
+	if c.Running == 0 {
+		return
+	}
+	i := c.Running - 1
+	c.Remaining[i] -= now.Sub(c.since)
+	if c.Remaining[i] < 0 {
+		c.Remaining[i] = 0
+	}
+	c.Running = 0
+}
+
+// Left returns the time a seat has left at the given moment.
+func (c *Clock) Left(seat int, now time.Time) time.Duration {
+	left := c.Remaining[seat-1]
+	if seat == c.Running {
+		left -= now.Sub(c.since)
+	}
+	if left < 0 {
+		return 0
+	}
+	return left
+}
+
+// Flagged returns the seat whose running clock has run out, or 0.
+func (c *Clock) Flagged(now time.Time) int {
+	if c.Running != 0 && c.Left(c.Running, now) == 0 {
+		return c.Running
+	}
+	return 0
+}
+
+// Millis returns every seat's remaining time in milliseconds.
+func (c *Clock) Millis(now time.Time) []int64 {
+	ms := make([]int64, len(c.Remaining))
+	for i := range ms {
+		ms[i] = c.Left(i+1, now).Milliseconds()
+	}
+	return ms
+}
+
+// StartTurn notes when the seat to move got the turn and starts its clock,
+// if the game is timed.
+func (g *Game) StartTurn(now time.Time) {
+	g.TurnStarted = now
+	if g.Clock != nil && g.State == StateActive {
+		g.Clock.Start(g.Turn, now)
+	}
+}
+
+// Clocks returns every seat's remaining time in milliseconds, or nil for
+// an untimed game.
+func (g *Game) Clocks(now time.Time) []int64 {
+	if g.Clock == nil {
+		return nil
+	}
+	return g.Clock.Millis(now)
+}
+
+// TimeControl returns the game's time control as a string, or "" for an
+// untimed game.
+func (g *Game) TimeControl() string {
+	if g.Clock == nil {
+		return ""
+	}
+	return g.Clock.Control.String()
+}
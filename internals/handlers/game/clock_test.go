@@ -0,0 +1,54 @@
+package game
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimeControl(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    TimeControl
+		wantErr bool
+	}{
+		{in: "5", want: TimeControl{Mode: SuddenDeath, Initial: 5 * time.Minute}},
+		{in: "0.5", want: TimeControl{Mode: SuddenDeath, Initial: 30 * time.Second}},
+		{in: "3+2", want: TimeControl{Mode: Fischer, Initial: 3 * time.Minute, Increment: 2 * time.Second}},
+		{in: "1+0", want: TimeControl{Mode: Fischer, Initial: time.Minute}},
+		{in: "5d3", want: TimeControl{Mode: Bronstein, Initial: 5 * time.Minute, Increment: 3 * time.Second}},
+		{in: "", wantErr: true},
+		{in: "0", wantErr: true},
+		{in: "-3", wantErr: true},
+		{in: "NaN", wantErr: true},
+		{in: "Inf", wantErr: true},
+		{in: "1e300", wantErr: true},
+		{in: "abc", wantErr: true},
+		{in: "3+", wantErr: true},
+		{in: "3+-1", wantErr: true},
+		{in: "3+1.5", wantErr: true},
+		{in: "3d", wantErr: true},
+		{in: "+2", wantErr: true},
+		{in: "3+2+1", wantErr: true},
+		{in: "3+99999999999999999", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseTimeControl(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ParseTimeControl(%q) = %+v, want an error", tt.in, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseTimeControl(%q) failed: %v", tt.in, err)
+			}
+			if got != tt.want {
+				t.Errorf("ParseTimeControl(%q) = %+v, want %+v", tt.in, got, tt.want)
+			}
+			if back, _ := ParseTimeControl(got.String()); back != got {
+				t.Errorf("%q does not read back: %+v", got.String(), back)
+			}
+		})
+	}
+}
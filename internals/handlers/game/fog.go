@@ -0,0 +1,40 @@
+package game
+
+// Hidden marks a cell the player cannot see in a fog-of-war game.
+const Hidden = -2
+
+// Visible reports whether seat can see the cell. Without fog every cell is
+// visible. In a fog-of-war game a player sees their own discs (their team's
+// in team games), the cells touching them and the neutral stones. Seat 0
+// stands for an onlooker who sees the whole board.
+func (g *Game) Visible(seat, row, col int) bool {
+	if !g.Fog || seat < 1 || g.Board[row][col] == Blocked {
+		return true
+	}
+	piece := g.Piece(seat)
+	for r := row - 1; r <= row+1; r++ {
+		for c := col - 1; c <= col+1; c++ {
+			if r >= 0 && r < g.Rows && c >= 0 && c < g.Cols && g.Board[r][c] == piece {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ViewFor returns a copy of the board as seen from seat, with every cell
+// the seat cannot see set to Hidden.
+func (g *Game) ViewFor(seat int) [][]int {
+	view := make([][]int, g.Rows)
+	for r := range view {
+		view[r] = make([]int, g.Cols)
+		for c := range view[r] {
+			if g.Visible(seat, r, c) {
+				view[r][c] = g.Board[r][c]
+			} else {
+				view[r][c] = Hidden
+			}
+		}
+	}
+	return view
+}
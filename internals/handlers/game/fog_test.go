@@ -0,0 +1,97 @@
+package game
+
+import "testing"
+
+func TestViewFor(t *testing.T) {
+	type cell struct{ row, col, value int }
+	tests := []struct {
+		name  string
+		fog   bool
+		teams bool
+		seat  int
+		cells []cell
+		// cells the seat sees; nil when it sees the whole board
+		visible []cell
+	}{
+		{
+			name:  "without fog everything is visible",
+			seat:  1,
+			cells: []cell{{6, 3, 2}},
+		},
+		{
+			name:    "empty fog board is all hidden",
+			fog:     true,
+			seat:    1,
+			visible: []cell{},
+		},
+		{
+			name:  "onlooker sees through the fog",
+			fog:   true,
+			seat:  0,
+			cells: []cell{{6, 3, 1}, {6, 0, 2}},
+		},
+		{
+			name:  "own disc lights up its neighbours",
+			fog:   true,
+			seat:  1,
+			cells: []cell{{6, 3, 1}, {6, 0, 2}, {6, 4, 2}},
+			visible: []cell{
+				{5, 2, 0}, {5, 3, 0}, {5, 4, 0},
+				{6, 2, 0}, {6, 3, 1}, {6, 4, 2},
+			},
+		},
+		{
+			name:    "neutral stones are always visible",
+			fog:     true,
+			seat:    2,
+			cells:   []cell{{6, 5, Blocked}, {6, 0, 1}},
+			visible: []cell{{6, 5, Blocked}},
+		},
+		{
+			name:  "teammates share their sight",
+			fog:   true,
+			teams: true,
+			seat:  3, // same team as seat 1
+			cells: []cell{{8, 0, 1}},
+			visible: []cell{
+				{7, 0, 0}, {7, 1, 0},
+				{8, 0, 1}, {8, 1, 0},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewMultiplayerGame("fog", []string{"a", "b"})
+			if tt.teams {
+				g = NewTeamGame("fog", []string{"a", "b", "c", "d"})
+			}
+			g.Fog = tt.fog
+			for _, c := range tt.cells {
+				g.Board[c.row][c.col] = c.value
+			}
+
+			want := make([][]int, g.Rows)
+			for r := range want {
+				want[r] = make([]int, g.Cols)
+				for c := range want[r] {
+					want[r][c] = g.Board[r][c]
+					if tt.visible != nil {
+						want[r][c] = Hidden
+					}
+				}
+			}
+			for _, c := range tt.visible {
+				want[c.row][c.col] = c.value
+			}
+
+			view := g.ViewFor(tt.seat)
+			for r := range want {
+				for c := range want[r] {
+					if view[r][c] != want[r][c] {
+						t.Errorf("cell %d,%d = %d, want %d", r, c, view[r][c], want[r][c])
+					}
+				}
+			}
+		})
+	}
+}
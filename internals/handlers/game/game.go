@@ -3,66 +3,239 @@ package game
 import (
 	"errors"
 	"fmt"
+	"strings"
 	"sync"
 	"time"
 )
 
+// SeatColours are the disc colours handed out by seat number (seat 1 first).
+var SeatColours = []string{"yellow", "red", "green", "blue"}
+
 type Game struct {
 	ID        string
 	Board     [][]int
-	Player1   string
-	Player2   string
-	Turn      int // 1 or 2 (whose turn)
+	Rows      int
+	Cols      int
+	Players   []string // Players[i] sits in seat i+1
+	Teams     []int    // team number per seat; nil in free-for-all games
+	Turn      int      // seat number (1..len(Players)) whose turn it is
+	Places    []int    // finishing place per seat, 0 while the seat is still playing
 	Mutex     sync.Mutex
-	Over      bool
+	State     State // see state.go; moves are only played while active
 	Moves     []string
 	StartTime time.Time
+
+	TurnStarted time.Time // when the seat to move got the turn
+
+	LayoutName string   // named obstacle layout, "random" or empty
+	Obstacles  [][2]int // {row, col} of every neutral stone
+	Fog        bool     // fog of war: players only see around their own discs
+
+	StartPosition string // board notation the game started from, empty for an empty board
+	Casual        bool   // casual games are saved but never change the rankings
+
+	Clock    *Clock // nil for an untimed game
+	Result   Result // win or draw once the game has finished
+	Reason   Reason // why the game finished or was aborted
+	SeriesID int64  // row of the best-of-N series the game is part of, 0 for a single game
+
+	nextPlace  int // best place still up for grabs
+	lastPlace  int // worst place still up for grabs
+	setupMoves int // moves that set up a sandbox position, never taken back
+}
+
+// TeamNames are the labels of the two sides in a team game.
+var TeamNames = []string{"A", "B"}
+
+// Limits on the board size players may ask for.
+const (
+	MinBoardSize = 4
+	MaxBoardSize = 12
+)
+
+// BoardSize returns the board dimensions used for a game with the given
+// number of seats. Games with more players get a larger board.
+func BoardSize(seats int) (rows, cols int) {
+	switch {
+	case seats >= 4:
+		return 9, 10
+	case seats == 3:
+		return 8, 9
+	default:
+		return 7, 6
+	}
 }
 
 func NewGame(id, p1, p2 string) *Game {
-	board := make([][]int, 7) // 7 rows
+	return NewMultiplayerGame(id, []string{p1, p2})
+}
+
+// NewMultiplayerGame creates a free-for-all game with one seat per player.
+func NewMultiplayerGame(id string, players []string) *Game {
+	rows, cols := BoardSize(len(players))
+	board := make([][]int, rows)
 	for i := range board {
-		board[i] = make([]int, 6) // 6 columns
+		board[i] = make([]int, cols)
 	}
 	return &Game{
 		ID:        id,
 		Board:     board,
-		Player1:   p1,
-		Player2:   p2,
-		Turn:      1, // player1 starts
-		Over:      false,
+		Rows:      rows,
+		Cols:      cols,
+		Players:   append([]string{}, players...),
+		Turn:      1, // seat 1 starts
+		Places:    make([]int, len(players)),
+		State:     StateWaiting,
 		Moves:     make([]string, 0),
 		StartTime: time.Now(),
+		nextPlace: 1,
+		lastPlace: len(players),
+	}
+}
+
+// Resize gives a game that has not started yet an empty board of another
+// size.
+func (g *Game) Resize(rows, cols int) error {
+	if len(g.Moves) > 0 || len(g.Obstacles) > 0 {
+		return errors.New("the board can only be resized before anything is on it")
+	}
+	if rows < MinBoardSize || rows > MaxBoardSize || cols < MinBoardSize || cols > MaxBoardSize {
+		return fmt.Errorf("rows and columns must be between %d and %d", MinBoardSize, MaxBoardSize)
+	}
+	g.Board = make([][]int, rows)
+	for i := range g.Board {
+		g.Board[i] = make([]int, cols)
+	}
+	g.Rows, g.Cols = rows, cols
+	return nil
+}
+
+// NewTeamGame creates a 2v2 game. The players are given in turn order
+// A1, B1, A2, B2, so odd seats play for team A and even seats for team B.
+// Teammates share a disc colour and connect four together.
+func NewTeamGame(id string, players []string) *Game {
+	g := NewMultiplayerGame(id, players)
+	g.Teams = make([]int, len(players))
+	for i := range players {
+		g.Teams[i] = i%len(TeamNames) + 1
+	}
+	g.lastPlace = len(TeamNames)
+	return g
+}
+
+// Rated reports whether the game counts towards the rankings.
+func (g *Game) Rated() bool {
+	return !g.Casual
+}
+
+// PlayerName returns the username sitting in the given seat.
+func (g *Game) PlayerName(seat int) string {
+	if seat < 1 || seat > len(g.Players) {
+		return ""
+	}
+	return g.Players[seat-1]
+}
+
+// Team returns the team the seat plays for, or 0 outside team games.
+func (g *Game) Team(seat int) int {
+	if g.Teams == nil || seat < 1 || seat > len(g.Teams) {
+		return 0
+	}
+	return g.Teams[seat-1]
+}
+
+// Piece returns the value a seat's discs take on the board. Teammates
+// share a piece so their discs count towards the same four in a row.
+func (g *Game) Piece(seat int) int {
+	if team := g.Team(seat); team != 0 {
+		return team
+	}
+	return seat
+}
+
+// Colour returns the disc colour of the given seat.
+func (g *Game) Colour(seat int) string {
+	return SeatColours[(g.Piece(seat)-1)%len(SeatColours)]
+}
+
+// Colours returns the disc colour of every seat, in seat order.
+func (g *Game) Colours() []string {
+	colours := make([]string, len(g.Players))
+	for i := range g.Players {
+		colours[i] = g.Colour(i + 1)
+	}
+	return colours
+}
+
+// Active reports whether the seat is still taking turns.
+func (g *Game) Active(seat int) bool {
+	return seat >= 1 && seat <= len(g.Places) && g.Places[seat-1] == 0
+}
+
+// ActiveSeats returns the seats that are still taking turns.
+func (g *Game) ActiveSeats() []int {
+	seats := []int{}
+	for i, place := range g.Places {
+		if place == 0 {
+			seats = append(seats, i+1)
+		}
+	}
+	return seats
+}
+
+// HasMoved reports whether the seat has played a move of its own, not
+// counting the moves that set up a sandbox position.
+func (g *Game) HasMoved(seat int) bool {
+	for _, move := range g.Moves[g.setupMoves:] {
+		if _, s := parseMove(move); s == seat {
+			return true
+		}
+	}
+	return false
+}
+
+// PlayedMoves returns the moves played since the starting position, leaving
+// out the moves that set it up.
+func (g *Game) PlayedMoves() []string {
+	return g.Moves[g.setupMoves:]
+}
+
+// advanceTurn passes the move to the next seat that is still playing.
+func (g *Game) advanceTurn() {
+	for i := 0; i < len(g.Players); i++ {
+		g.Turn = g.Turn%len(g.Players) + 1
+		if g.Active(g.Turn) {
+			return
+		}
 	}
 }
 
 // PlaceDisc tries to drop a disc in a column
 func (g *Game) PlaceDisc(player int, col int) (int, int, error) {
-	if col < 0 || col >= 6 {
+	if col < 0 || col >= g.Cols {
 		return -1, -1, errors.New("invalid column")
 	}
 	if player != g.Turn {
 		return -1, -1, errors.New("not your turn")
 	}
 
-	// find lowest empty row
-	for row := len(g.Board) - 1; row >= 0; row-- {
-		if g.Board[row][col] == 0 {
-			g.Board[row][col] = player
-			g.Moves = append(g.Moves, fmt.Sprintf("%d:%d", col, player))
-			// switch turn
-			if g.Turn == 1 {
-				g.Turn = 2
-			} else {
-				g.Turn = 1
-			}
-			return row, col, nil
-		}
+	if g.Board[0][col] != 0 {
+		return -1, -1, errors.New("column is full")
+	}
+
+	// let the disc fall until it lands on a disc, an obstacle or the bottom
+	row := 0
+	for row+1 < g.Rows && g.Board[row+1][col] == 0 {
+		row++
 	}
-	return -1, -1, errors.New("column is full")
+	g.Board[row][col] = g.Piece(player)
+	g.Moves = append(g.Moves, fmt.Sprintf("%d:%d", col, player))
+	g.advanceTurn()
+	return row, col, nil
 }
 
-// CheckWin checks if the last move caused a win
+// CheckWin checks if the last move caused a win. player is the piece on
+// the board (see Piece), which is the seat number outside team games.
 func (g *Game) CheckWin(row, col, player int) bool {
 	directions := [][]int{
 		{0, 1},  // →
@@ -74,14 +247,14 @@ func (g *Game) CheckWin(row, col, player int) bool {
 		count := 1
 		// forward
 		r, c := row+d[0], col+d[1]
-		for r >= 0 && r < 7 && c >= 0 && c < 6 && g.Board[r][c] == player {
+		for r >= 0 && r < g.Rows && c >= 0 && c < g.Cols && g.Board[r][c] == player {
 			count++
 			r += d[0]
 			c += d[1]
 		}
 		// backward
 		r, c = row-d[0], col-d[1]
-		for r >= 0 && r < 7 && c >= 0 && c < 6 && g.Board[r][c] == player {
+		for r >= 0 && r < g.Rows && c >= 0 && c < g.Cols && g.Board[r][c] == player {
 			count++
 			r -= d[0]
 			c -= d[1]
@@ -93,12 +266,114 @@ func (g *Game) CheckWin(row, col, player int) bool {
 	return false
 }
 
-// CheckDraw returns true if the board is full
+// CheckDraw returns true if the board is full. Obstacles count as filled
+// cells, so a column blocked at the top can never be played again.
 func (g *Game) CheckDraw() bool {
-	for c := 0; c < 6; c++ {
+	for c := 0; c < g.Cols; c++ {
 		if g.Board[0][c] == 0 {
 			return false
 		}
 	}
 	return true
 }
+
+// RecordWin gives the seat that just connected four, and its teammate in
+// team games, the best open place. In a two-sided game that ends the game;
+// with more players the others keep playing for the remaining places until
+// one side is left.
+func (g *Game) RecordWin(seat int) {
+	g.placeSide(seat, g.nextPlace)
+	g.nextPlace++
+	g.settle(ReasonConnectFour)
+}
+
+// Eliminate drops a seat out of the game (for example after a forfeit),
+// giving it the worst open place. A team goes down together. If that
+// leaves a single side the game finishes for the given reason.
+func (g *Game) Eliminate(seat int, reason Reason) {
+	if !g.Active(seat) {
+		return
+	}
+	g.placeSide(seat, g.lastPlace)
+	g.lastPlace--
+	if !g.Active(g.Turn) {
+		g.advanceTurn()
+	}
+	g.settle(reason)
+}
+
+// RecordDraw ends the game with every remaining seat sharing the best open
+// place, used when the board fills up or the players agree to a draw.
+func (g *Game) RecordDraw(reason Reason) {
+	for _, seat := range g.ActiveSeats() {
+		g.Places[seat-1] = g.nextPlace
+	}
+	g.finish(reason)
+}
+
+// placeSide gives every active seat playing the same piece as seat the
+// given place.
+func (g *Game) placeSide(seat, place int) {
+	piece := g.Piece(seat)
+	for _, s := range g.ActiveSeats() {
+		if g.Piece(s) == piece {
+			g.Places[s-1] = place
+		}
+	}
+}
+
+// settle ends the game once at most one side is still playing.
+func (g *Game) settle(reason Reason) {
+	active := g.ActiveSeats()
+	sides := make(map[int]bool)
+	for _, seat := range active {
+		sides[g.Piece(seat)] = true
+	}
+	if len(sides) > 1 {
+		return
+	}
+	for _, seat := range active {
+		g.Places[seat-1] = g.nextPlace
+	}
+	g.finish(reason)
+}
+
+// Winner returns the name of the first-placed player, or "draw" when first
+// place is shared. A winning team is named after both its players. An
+// aborted game has no winner.
+func (g *Game) Winner() string {
+	if g.State == StateAborted {
+		return ""
+	}
+	names := []string{}
+	piece := 0
+	for i, place := range g.Places {
+		if place != 1 {
+			continue
+		}
+		if piece != 0 && piece != g.Piece(i+1) {
+			return "draw"
+		}
+		piece = g.Piece(i + 1)
+		names = append(names, g.Players[i])
+	}
+	if len(names) == 0 {
+		return "draw"
+	}
+	return strings.Join(names, " & ")
+}
+
+// Placements maps each player to their finishing place.
+func (g *Game) Placements() map[string]int {
+	placements := make(map[string]int, len(g.Players))
+	for i, name := range g.Players {
+		placements[name] = g.Places[i]
+	}
+	return placements
+}
+
+// Clone returns a deep copy of the game that can be searched or inspected
+// without holding the game's mutex.
+func (g *Game) Clone() *Game {
+	return copyGame(g)
+}
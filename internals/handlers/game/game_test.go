@@ -0,0 +1,51 @@
+package game
+
+import "testing"
+
+func TestNewTeamGameSeating(t *testing.T) {
+	g := NewTeamGame("teams", []string{"a1", "b1", "a2", "b2"})
+	tests := []struct {
+		seat   int
+		team   int
+		colour string
+	}{
+		{seat: 1, team: 1, colour: "yellow"},
+		{seat: 2, team: 2, colour: "red"},
+		{seat: 3, team: 1, colour: "yellow"},
+		{seat: 4, team: 2, colour: "red"},
+	}
+	for _, tt := range tests {
+		if got := g.Team(tt.seat); got != tt.team {
+			t.Errorf("Team(%d) = %d, want %d", tt.seat, got, tt.team)
+		}
+		if got := g.Piece(tt.seat); got != tt.team {
+			t.Errorf("Piece(%d) = %d, want the team %d", tt.seat, got, tt.team)
+		}
+		if got := g.Colour(tt.seat); got != tt.colour {
+			t.Errorf("Colour(%d) = %q, want %q", tt.seat, got, tt.colour)
+		}
+	}
+	if rows, cols := BoardSize(4); g.Rows != rows || g.Cols != cols {
+		t.Errorf("board is %dx%d, want %dx%d", g.Rows, g.Cols, rows, cols)
+	}
+}
+
+func TestTeammatesConnectFourTogether(t *testing.T) {
+	g := NewTeamGame("teams", []string{"a1", "b1", "a2", "b2"})
+	// Seats 1 and 3 fill the bottom row from the left while seats 2 and 4
+	// stack on top of them
+	var row, col int
+	for i, c := range []int{0, 0, 1, 1, 2, 2, 3} {
+		seat := g.Turn
+		var err error
+		if row, col, err = g.PlaceDisc(seat, c); err != nil {
+			t.Fatalf("move %d: %v", i+1, err)
+		}
+		if i < 6 && g.CheckWin(row, col, g.Piece(seat)) {
+			t.Fatalf("move %d already wins", i+1)
+		}
+	}
+	if !g.CheckWin(row, col, g.Piece(3)) {
+		t.Errorf("discs of seats 1 and 3 in a row do not win for their team:\n%s", g.Notation())
+	}
+}
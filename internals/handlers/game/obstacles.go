@@ -0,0 +1,106 @@
+package game
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// Blocked marks a cell taken by a neutral stone. Nobody can play there and
+// it never counts towards anyone's four in a row.
+const Blocked = -1
+
+// RandomLayout is the layout name asking for randomly placed obstacles.
+const RandomLayout = "random"
+
+// Layouts are the named obstacle layouts, given as {row, col} cells on the
+// standard 7x6 board. Row 0 is the top of the board.
+var Layouts = map[string][][2]int{
+	"pillars": {{4, 1}, {4, 4}},
+	"steps":   {{6, 0}, {5, 1}, {5, 4}, {6, 5}},
+	"bridge":  {{3, 2}, {3, 3}},
+	"funnel":  {{2, 0}, {3, 0}, {2, 5}, {3, 5}},
+}
+
+// RandomObstacles picks pairs of cells mirrored around the board's vertical
+// centre line, so neither side gets a better-shaped board. The top row is
+// left free so every column stays playable.
+func RandomObstacles(rows, cols, pairs int) [][2]int {
+	candidates := [][2]int{}
+	for r := 1; r < rows; r++ {
+		for c := 0; c < (cols+1)/2; c++ {
+			candidates = append(candidates, [2]int{r, c})
+		}
+	}
+	rand.Shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+	if pairs > len(candidates) {
+		pairs = len(candidates)
+	}
+
+	cells := [][2]int{}
+	for _, cell := range candidates[:pairs] {
+		cells = append(cells, cell)
+		if mirror := cols - 1 - cell[1]; mirror != cell[1] {
+			cells = append(cells, [2]int{cell[0], mirror})
+		}
+	}
+	return cells
+}
+
+// PlaceObstacles puts neutral stones on an empty board and remembers the
+// layout so the game can be replayed.
+func (g *Game) PlaceObstacles(name string, cells [][2]int) error {
+	if len(g.Moves) > 0 {
+		return errors.New("obstacles must be placed before the first move")
+	}
+	for _, cell := range cells {
+		r, c := cell[0], cell[1]
+		if r < 0 || r >= g.Rows || c < 0 || c >= g.Cols {
+			return fmt.Errorf("obstacle %d,%d is off the board", r, c)
+		}
+	}
+	for _, cell := range cells {
+		g.Board[cell[0]][cell[1]] = Blocked
+	}
+	g.LayoutName = name
+	g.Obstacles = append([][2]int{}, cells...)
+	return nil
+}
+
+// Layout returns the obstacle cells as "row,col;row,col", the form the
+// layout is stored in. It is empty when the board has no obstacles.
+func (g *Game) Layout() string {
+	cells := make([]string, len(g.Obstacles))
+	for i, cell := range g.Obstacles {
+		cells[i] = fmt.Sprintf("%d,%d", cell[0], cell[1])
+	}
+	return strings.Join(cells, ";")
+}
+
+// ParseLayout reads a layout written by Layout.
+func ParseLayout(layout string) ([][2]int, error) {
+	cells := [][2]int{}
+	if layout == "" {
+		return cells, nil
+	}
+	for _, part := range strings.Split(layout, ";") {
+		rc := strings.Split(part, ",")
+		if len(rc) != 2 {
+			return nil, fmt.Errorf("bad obstacle %q", part)
+		}
+		r, err := strconv.Atoi(rc[0])
+		if err != nil {
+			return nil, fmt.Errorf("bad obstacle %q", part)
+		}
+		c, err := strconv.Atoi(rc[1])
+		if err != nil {
+			return nil, fmt.Errorf("bad obstacle %q", part)
+		}
+		cells = append(cells, [2]int{r, c})
+	}
+	return cells, nil
+}
@@ -0,0 +1,151 @@
+package game
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNamedLayouts(t *testing.T) {
+	rows, cols := BoardSize(2)
+	for name, cells := range Layouts {
+		t.Run(name, func(t *testing.T) {
+			g := NewGame("layout", "a", "b")
+			if err := g.PlaceObstacles(name, cells); err != nil {
+				t.Fatalf("the layout does not fit the %dx%d board: %v", rows, cols, err)
+			}
+			blocked := make(map[[2]int]bool)
+			for _, cell := range cells {
+				blocked[cell] = true
+			}
+			for _, cell := range cells {
+				if cell[0] == 0 {
+					t.Errorf("obstacle %v blocks the top row", cell)
+				}
+				if mirror := [2]int{cell[0], cols - 1 - cell[1]}; !blocked[mirror] {
+					t.Errorf("obstacle %v has no mirror at %v", cell, mirror)
+				}
+			}
+		})
+	}
+}
+
+func TestRandomObstacles(t *testing.T) {
+	tests := []struct {
+		rows, cols, pairs int
+		min, max          int // obstacles placed
+	}{
+		{rows: 7, cols: 6, pairs: 3, min: 6, max: 6},
+		{rows: 8, cols: 9, pairs: 3, min: 3, max: 6}, // a stone in the middle column has no mirror
+		{rows: 4, cols: 4, pairs: 100, min: 12, max: 12},
+		{rows: 7, cols: 6, pairs: 0, min: 0, max: 0},
+	}
+	for _, tt := range tests {
+		for run := 0; run < 20; run++ {
+			cells := RandomObstacles(tt.rows, tt.cols, tt.pairs)
+			if len(cells) < tt.min || len(cells) > tt.max {
+				t.Errorf("%dx%d with %d pairs: %d obstacles, want %d to %d", tt.rows, tt.cols, tt.pairs, len(cells), tt.min, tt.max)
+			}
+			seen := make(map[[2]int]bool)
+			for _, cell := range cells {
+				if cell[0] < 1 || cell[0] >= tt.rows || cell[1] < 0 || cell[1] >= tt.cols {
+					t.Errorf("%dx%d: obstacle %v is off the board or in the top row", tt.rows, tt.cols, cell)
+				}
+				if seen[cell] {
+					t.Errorf("%dx%d: obstacle %v placed twice", tt.rows, tt.cols, cell)
+				}
+				seen[cell] = true
+			}
+			for cell := range seen {
+				if !seen[[2]int{cell[0], tt.cols - 1 - cell[1]}] {
+					t.Errorf("%dx%d: obstacle %v is not mirrored", tt.rows, tt.cols, cell)
+				}
+			}
+		}
+	}
+}
+
+func TestPlaceObstacles(t *testing.T) {
+	tests := []struct {
+		name    string
+		cells   [][2]int
+		moved   bool
+		wantErr bool
+	}{
+		{name: "inside the board", cells: [][2]int{{6, 0}, {6, 5}}},
+		{name: "off the bottom", cells: [][2]int{{7, 0}}, wantErr: true},
+		{name: "off the side", cells: [][2]int{{3, 6}}, wantErr: true},
+		{name: "negative", cells: [][2]int{{-1, 2}}, wantErr: true},
+		{name: "after the first move", cells: [][2]int{{6, 0}}, moved: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGame("obstacles", "a", "b")
+			if tt.moved {
+				g.PlaceDisc(1, 3)
+			}
+			err := g.PlaceObstacles("custom", tt.cells)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("PlaceObstacles() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if len(g.Obstacles) != 0 {
+					t.Errorf("a rejected layout left obstacles %v", g.Obstacles)
+				}
+				return
+			}
+			for _, cell := range tt.cells {
+				if g.Board[cell[0]][cell[1]] != Blocked {
+					t.Errorf("cell %v is not blocked", cell)
+				}
+			}
+		})
+	}
+}
+
+func TestDiscLandsOnObstacle(t *testing.T) {
+	g := NewGame("obstacles", "a", "b")
+	g.PlaceObstacles("custom", [][2]int{{6, 2}, {5, 2}})
+	row, _, err := g.PlaceDisc(1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if row != 4 {
+		t.Errorf("disc landed in row %d, want 4 on top of the stones", row)
+	}
+	if g.CheckWin(6, 2, Blocked) {
+		t.Error("neutral stones count as a four in a row")
+	}
+}
+
+func TestLayoutRoundTrip(t *testing.T) {
+	tests := []struct {
+		layout  string
+		want    [][2]int
+		wantErr bool
+	}{
+		{layout: "", want: [][2]int{}},
+		{layout: "4,1;4,4", want: [][2]int{{4, 1}, {4, 4}}},
+		{layout: "6,0", want: [][2]int{{6, 0}}},
+		{layout: "4", wantErr: true},
+		{layout: "4,x", wantErr: true},
+		{layout: "4,1;", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseLayout(tt.layout)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseLayout(%q) error = %v, want error %v", tt.layout, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseLayout(%q) = %v, want %v", tt.layout, got, tt.want)
+		}
+		g := NewGame("layout", "a", "b")
+		g.PlaceObstacles("custom", got)
+		if g.Layout() != tt.layout {
+			t.Errorf("Layout() = %q, want %q", g.Layout(), tt.layout)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package game
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// NewGameFromPosition creates a free-for-all game that starts from a given
+// position instead of an empty board, for teaching, puzzles and handicap
+// games. The position is either
+//
+//   - a move string: the columns played so far, 1-based, e.g. "4453", with
+//     "a", "b" and "c" for columns 10 to 12 on wider boards, or
+//   - board notation: the rows from top to bottom separated by "/", with
+//     "." for an empty cell, "1".."4" for a seat's disc and "#" for a
+//     neutral stone, e.g. "....../....../....../....../....../...2../..11..".
+//
+// The position must be reachable: discs rest on something, the seats have
+// taken turns in order, and nobody has connected four yet. The side to move
+// is worked out from the disc counts.
+func NewGameFromPosition(id string, players []string, position string) (*Game, error) {
+	g := NewMultiplayerGame(id, players)
+	position = strings.TrimSpace(position)
+	if position == "" {
+		return nil, errors.New("empty position")
+	}
+	if strings.Contains(position, "/") {
+		if err := g.loadBoard(position); err != nil {
+			return nil, err
+		}
+	} else if err := g.loadMoves(position); err != nil {
+		return nil, err
+	}
+	if g.CheckDraw() {
+		return nil, errors.New("the board is already full")
+	}
+	g.StartPosition = g.Notation()
+	g.setupMoves = len(g.Moves)
+	return g, nil
+}
+
+// moveColumns are the characters a move string writes the columns with,
+// from the left.
+const moveColumns = "123456789abc"
+
+// loadMoves replays a move string from the empty board.
+func (g *Game) loadMoves(moves string) error {
+	for i, ch := range moves {
+		col := strings.IndexRune(moveColumns[:g.Cols], unicode.ToLower(ch))
+		if col < 0 {
+			return fmt.Errorf("move %d: %q is not one of the columns %s", i+1, ch, moveColumns[:g.Cols])
+		}
+		seat := g.Turn
+		row, _, err := g.PlaceDisc(seat, col)
+		if err != nil {
+			return fmt.Errorf("move %d: %v", i+1, err)
+		}
+		if g.CheckWin(row, col, g.Piece(seat)) {
+			return fmt.Errorf("move %d already connects four", i+1)
+		}
+	}
+	return nil
+}
+
+// loadBoard sets up a position given in board notation and works out whose
+// turn it is.
+func (g *Game) loadBoard(notation string) error {
+	rows := strings.Split(notation, "/")
+	if len(rows) != g.Rows {
+		return fmt.Errorf("expected %d rows, got %d", g.Rows, len(rows))
+	}
+	counts := make([]int, len(g.Players))
+	obstacles := [][2]int{}
+	for r, line := range rows {
+		if len(line) != g.Cols {
+			return fmt.Errorf("row %d: expected %d cells, got %d", r+1, g.Cols, len(line))
+		}
+		for c, ch := range line {
+			switch {
+			case ch == '.':
+			case ch == '#':
+				g.Board[r][c] = Blocked
+				obstacles = append(obstacles, [2]int{r, c})
+			case ch >= '1' && int(ch-'0') <= len(g.Players):
+				seat := int(ch - '0')
+				g.Board[r][c] = seat
+				counts[seat-1]++
+			default:
+				return fmt.Errorf("row %d: unexpected %q", r+1, ch)
+			}
+		}
+	}
+	if len(obstacles) > 0 {
+		g.LayoutName = "custom"
+		g.Obstacles = obstacles
+	}
+
+	// Gravity: every disc rests on the bottom, a disc or an obstacle
+	for r := 0; r < g.Rows-1; r++ {
+		for c := 0; c < g.Cols; c++ {
+			if g.Board[r][c] > 0 && g.Board[r+1][c] == 0 {
+				return fmt.Errorf("the disc at row %d, column %d is floating", r+1, c+1)
+			}
+		}
+	}
+
+	// Seats move in order, so no seat can be more than one disc ahead and
+	// a later seat never has more discs than an earlier one
+	for i := 1; i < len(counts); i++ {
+		if counts[i] > counts[i-1] || counts[0]-counts[i] > 1 {
+			return fmt.Errorf("disc counts %v cannot come from seats taking turns", counts)
+		}
+	}
+	g.Turn = 1
+	for i, n := range counts {
+		if n < counts[0] {
+			g.Turn = i + 1
+			break
+		}
+	}
+
+	for r := 0; r < g.Rows; r++ {
+		for c := 0; c < g.Cols; c++ {
+			if g.Board[r][c] > 0 && g.CheckWin(r, c, g.Board[r][c]) {
+				return fmt.Errorf("seat %d has already connected four", g.Board[r][c])
+			}
+		}
+	}
+	return nil
+}
+
+// Notation writes the current board in board notation.
+func (g *Game) Notation() string {
+	rows := make([]string, g.Rows)
+	for r := range g.Board {
+		var b strings.Builder
+		for _, v := range g.Board[r] {
+			switch {
+			case v == 0:
+				b.WriteByte('.')
+			case v == Blocked:
+				b.WriteByte('#')
+			default:
+				b.WriteByte(byte('0' + v))
+			}
+		}
+		rows[r] = b.String()
+	}
+	return strings.Join(rows, "/")
+}
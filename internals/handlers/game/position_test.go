@@ -0,0 +1,83 @@
+package game
+
+import "testing"
+
+func TestNewGameFromPosition(t *testing.T) {
+	tests := []struct {
+		name      string
+		seats     int
+		position  string
+		wantTurn  int
+		wantStart string
+		wantErr   bool
+	}{
+		{
+			name:      "move string",
+			seats:     2,
+			position:  "4453",
+			wantTurn:  1,
+			wantStart: "....../....../....../....../....../...2../..211.",
+		},
+		{
+			name:      "board notation",
+			seats:     2,
+			position:  "....../....../....../....../....../...2../..11..",
+			wantTurn:  2,
+			wantStart: "....../....../....../....../....../...2../..11..",
+		},
+		{
+			name:      "board notation with an obstacle",
+			seats:     2,
+			position:  "....../....../....../....../....../....../#.1...",
+			wantTurn:  2,
+			wantStart: "....../....../....../....../....../....../#.1...",
+		},
+		{
+			name:      "three seats",
+			seats:     3,
+			position:  "123",
+			wantTurn:  1,
+			wantStart: "........./........./........./........./........./........./........./123......",
+		},
+		{
+			name:      "columns past 9 are letters",
+			seats:     4,
+			position:  "a1A2",
+			wantTurn:  1,
+			wantStart: "........../........../........../........../........../........../........../.........3/24.......1",
+		},
+		{name: "empty", seats: 2, position: "", wantErr: true},
+		{name: "letter past the last column", seats: 4, position: "b", wantErr: true},
+		{name: "letter on a narrow board", seats: 2, position: "a", wantErr: true},
+		{name: "column off the board", seats: 2, position: "47", wantErr: true},
+		{name: "not a column", seats: 2, position: "4x", wantErr: true},
+		{name: "already connected four", seats: 2, position: "1212121", wantErr: true},
+		{name: "floating disc", seats: 2, position: "....../....../....../....../....../..1.../......", wantErr: true},
+		{name: "too many discs for one seat", seats: 2, position: "....../....../....../....../....../....../111...", wantErr: true},
+		{name: "second seat ahead", seats: 2, position: "....../....../....../....../....../....../2.....", wantErr: true},
+		{name: "wrong number of rows", seats: 2, position: "....../..1...", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, err := NewGameFromPosition("test", make([]string, tt.seats), tt.position)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("NewGameFromPosition(%q) succeeded, want an error", tt.position)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewGameFromPosition(%q) failed: %v", tt.position, err)
+			}
+			if g.Turn != tt.wantTurn {
+				t.Errorf("turn = %d, want %d", g.Turn, tt.wantTurn)
+			}
+			if g.StartPosition != tt.wantStart {
+				t.Errorf("start position = %q, want %q", g.StartPosition, tt.wantStart)
+			}
+			if len(g.PlayedMoves()) != 0 {
+				t.Errorf("played moves = %v, want none", g.PlayedMoves())
+			}
+		})
+	}
+}
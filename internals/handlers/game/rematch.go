@@ -0,0 +1,48 @@
+package game
+
+import "strings"
+
+// Rematch creates a new game with the same settings as g: board, teams,
+// obstacles, fog, time control and starting position. The players are given
+// in their new seat order.
+func (g *Game) Rematch(id string, players []string) (*Game, error) {
+	r := NewMultiplayerGame(id, players)
+	if g.Teams != nil {
+		r = NewTeamGame(id, players)
+	}
+	if r.Rows != g.Rows || r.Cols != g.Cols {
+		if err := r.Resize(g.Rows, g.Cols); err != nil {
+			return nil, err
+		}
+	}
+	r.Fog = g.Fog
+	r.Casual = g.Casual
+	if g.Clock != nil {
+		r.Clock = NewClock(g.Clock.Control, len(players))
+	}
+
+	switch {
+	case g.setupMoves > 0:
+		var moves strings.Builder
+		for _, move := range g.Moves[:g.setupMoves] {
+			col, _ := parseMove(move)
+			moves.WriteByte(moveColumns[col])
+		}
+		if err := r.loadMoves(moves.String()); err != nil {
+			return nil, err
+		}
+		r.StartPosition = g.StartPosition
+		r.setupMoves = len(r.Moves)
+	case g.StartPosition != "":
+		// Board notation carries its own obstacles
+		if err := r.loadBoard(g.StartPosition); err != nil {
+			return nil, err
+		}
+		r.StartPosition = g.StartPosition
+	case len(g.Obstacles) > 0:
+		if err := r.PlaceObstacles(g.LayoutName, g.Obstacles); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
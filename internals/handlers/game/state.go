@@ -0,0 +1,117 @@
+package game
+
+import (
+	"fmt"
+	"time"
+)
+
+// State is where a game is in its lifecycle.
+type State string
+
+const (
+	StateWaiting  State = "waiting"  // created, the players are still being seated
+	StateActive   State = "active"   // moves are being played
+	StatePaused   State = "paused"   // waiting for a disconnected player to come back
+	StateFinished State = "finished" // over, with a result
+	StateAborted  State = "aborted"  // called off without a result
+)
+
+// Result is the outcome of a finished game.
+type Result string
+
+const (
+	ResultWin  Result = "win"
+	ResultDraw Result = "draw"
+)
+
+// Reason is why a game ended.
+type Reason string
+
+const (
+	ReasonConnectFour Reason = "connect_four"
+	ReasonBoardFull   Reason = "board_full"
+	ReasonResignation Reason = "resignation"
+	ReasonTimeout     Reason = "timeout"
+	ReasonDisconnect  Reason = "disconnect"
+	ReasonAgreement   Reason = "agreement"
+	ReasonAbort       Reason = "abort"
+)
+
+// transitions lists the states a game may move to from each state.
+var transitions = map[State][]State{
+	StateWaiting: {StateActive, StateAborted},
+	StateActive:  {StatePaused, StateFinished, StateAborted},
+	StatePaused:  {StateActive, StateFinished, StateAborted},
+}
+
+// setState moves the game to another state if the lifecycle allows it.
+func (g *Game) setState(to State) error {
+	for _, next := range transitions[g.State] {
+		if next == to {
+			g.State = to
+			return nil
+		}
+	}
+	return fmt.Errorf("game %s cannot go from %s to %s", g.ID, g.State, to)
+}
+
+// Over reports whether the game has finished or was aborted.
+func (g *Game) Over() bool {
+	return g.State == StateFinished || g.State == StateAborted
+}
+
+// Start makes a waiting game active and hands the first turn out.
+func (g *Game) Start(now time.Time) error {
+	if err := g.setState(StateActive); err != nil {
+		return err
+	}
+	g.StartTurn(now)
+	return nil
+}
+
+// Pause holds an active game while a player is away. The clock stops and
+// no moves can be played until the game is resumed.
+func (g *Game) Pause(now time.Time) error {
+	if err := g.setState(StatePaused); err != nil {
+		return err
+	}
+	if g.Clock != nil {
+		g.Clock.Stop(now)
+	}
+	return nil
+}
+
+// Resume carries on with a paused game, giving the seat to move a fresh
+// start on its turn.
+func (g *Game) Resume(now time.Time) error {
+	if err := g.setState(StateActive); err != nil {
+		return err
+	}
+	g.StartTurn(now)
+	return nil
+}
+
+// Abort calls the game off without a result. Nobody gets a place.
+func (g *Game) Abort(reason Reason) error {
+	if err := g.setState(StateAborted); err != nil {
+		return err
+	}
+	if g.Clock != nil {
+		g.Clock.Stop(time.Now())
+	}
+	g.Reason = reason
+	return nil
+}
+
+// finish ends the game for the given reason. The result follows from the
+// places handed out: a shared first place is a draw.
+func (g *Game) finish(reason Reason) {
+	if err := g.setState(StateFinished); err != nil {
+		return
+	}
+	g.Result = ResultWin
+	if g.Winner() == "draw" {
+		g.Result = ResultDraw
+	}
+	g.Reason = reason
+}
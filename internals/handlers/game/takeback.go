@@ -0,0 +1,56 @@
+package game
+
+import (
+	"errors"
+	"fmt"
+)
+
+// TakeBack undoes the moves back to and including seat's last move, so it
+// is seat's turn again, and returns how many moves were taken back. The
+// moves that set up a sandbox position cannot be taken back, and neither
+// can anything once a place has been handed out.
+func (g *Game) TakeBack(seat int) (int, error) {
+	if g.Over() {
+		return 0, errors.New("the game is over")
+	}
+	for _, place := range g.Places {
+		if place != 0 {
+			return 0, errors.New("places have already been decided")
+		}
+	}
+	last := -1
+	for i := len(g.Moves) - 1; i >= g.setupMoves; i-- {
+		if _, s := parseMove(g.Moves[i]); s == seat {
+			last = i
+			break
+		}
+	}
+	if last < 0 {
+		return 0, errors.New("no move to take back")
+	}
+
+	for i := len(g.Moves) - 1; i >= last; i-- {
+		col, _ := parseMove(g.Moves[i])
+		g.liftDisc(col)
+	}
+	undone := len(g.Moves) - last
+	g.Moves = g.Moves[:last]
+	g.Turn = seat
+	return undone, nil
+}
+
+// liftDisc removes the topmost disc from a column.
+func (g *Game) liftDisc(col int) {
+	for row := 0; row < g.Rows; row++ {
+		if g.Board[row][col] > 0 {
+			g.Board[row][col] = 0
+			return
+		}
+	}
+}
+
+// parseMove reads a move as stored in Moves, "col:seat".
+func parseMove(move string) (col, seat int) {
+	fmt.Sscanf(move, "%d:%d", &col, &seat)
+	return col, seat
+}
@@ -0,0 +1,94 @@
+package game
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTakeBack(t *testing.T) {
+	tests := []struct {
+		name       string
+		seats      int
+		position   string // sandbox set-up, empty for a fresh game
+		play       []int  // columns played after the set-up, seats taking turns
+		seat       int
+		wantUndone int
+		wantErr    bool
+	}{
+		{name: "own move and the reply", seats: 2, play: []int{3, 3, 2, 4}, seat: 1, wantUndone: 2},
+		{name: "own move only", seats: 2, play: []int{3, 3, 2, 4}, seat: 2, wantUndone: 1},
+		{name: "around the table", seats: 3, play: []int{0, 1, 2, 3}, seat: 2, wantUndone: 3},
+		{name: "nothing played", seats: 2, seat: 1, wantErr: true},
+		{name: "set-up moves stay", seats: 2, position: "4453", seat: 1, wantErr: true},
+		{name: "move after the set-up", seats: 2, position: "4453", play: []int{0, 5}, seat: 1, wantUndone: 2},
+		{name: "only set-up moves of that seat", seats: 2, position: "4453", play: []int{0}, seat: 2, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			players := []string{"a", "b", "c"}[:tt.seats]
+			g := NewMultiplayerGame("t", players)
+			if tt.position != "" {
+				var err error
+				if g, err = NewGameFromPosition("t", players, tt.position); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, col := range tt.play {
+				if _, _, err := g.PlaceDisc(g.Turn, col); err != nil {
+					t.Fatal(err)
+				}
+			}
+			before := append([]string{}, g.Moves...)
+
+			undone, err := g.TakeBack(tt.seat)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("TakeBack(%d) took back %d moves, want an error", tt.seat, undone)
+				}
+				if len(g.Moves) != len(before) {
+					t.Errorf("a refused takeback changed the moves to %v", g.Moves)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("TakeBack(%d): %v", tt.seat, err)
+			}
+			if undone != tt.wantUndone || len(g.Moves) != len(before)-tt.wantUndone {
+				t.Errorf("undone = %d, moves %v, want %d undone from %v", undone, g.Moves, tt.wantUndone, before)
+			}
+			if g.Turn != tt.seat {
+				t.Errorf("turn = %d, want %d", g.Turn, tt.seat)
+			}
+
+			// the board must be exactly as if the undone moves were never played
+			replay := NewMultiplayerGame("t", players)
+			if tt.position != "" {
+				replay, _ = NewGameFromPosition("t", players, tt.position)
+			}
+			for _, move := range g.Moves[len(replay.Moves):] {
+				col, seat := parseMove(move)
+				replay.PlaceDisc(seat, col)
+			}
+			if got, want := g.Notation(), replay.Notation(); got != want {
+				t.Errorf("board = %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestTakeBackAfterPlaces(t *testing.T) {
+	g := NewMultiplayerGame("t", []string{"a", "b", "c"})
+	g.PlaceDisc(1, 0)
+	g.RecordWin(1)
+	if _, err := g.TakeBack(1); err == nil {
+		t.Error("took back a move after seat 1 was placed")
+	}
+
+	g = NewGame("t", "a", "b")
+	g.Start(time.Now())
+	g.PlaceDisc(1, 0)
+	g.RecordDraw(ReasonAgreement)
+	if _, err := g.TakeBack(1); err == nil {
+		t.Error("took back a move in a finished game")
+	}
+}
@@ -1,13 +1,18 @@
 package matchmaking
 
 import (
+	"Connect-4/internals/handlers/game"
 	"database/sql"
 	"encoding/json"
+	"fmt"
 	"log"
+	"math"
 	"net/http"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -21,76 +26,97 @@ var (
 func InitRankingDB(database *sql.DB) {
 	db = database
 }
-func SaveGame(player1, player2, winner string, moves []string) {
+
+// SaveGame stores a finished game together with every seat's placement
+// and returns its id, 0 if it could not be saved.
+func SaveGame(g *game.Game) int64 {
 	rankMutex.Lock()
 	defer rankMutex.Unlock()
 
-	movesStr := strings.Join(moves, ",") // store moves as comma-separated string
+	movesStr := strings.Join(g.PlayedMoves(), ",") // store moves as comma-separated string, after the start position
+	placements := make([]string, len(g.Players))
+	for i, name := range g.Players {
+		placements[i] = fmt.Sprintf("%s:%d", name, g.Places[i])
+	}
+	// Remaining time per seat in milliseconds, empty for untimed games
+	remaining := make([]string, 0, len(g.Players))
+	for _, ms := range g.Clocks(time.Now()) {
+		remaining = append(remaining, strconv.FormatInt(ms, 10))
+	}
+	clocks := strings.Join(remaining, ",")
+	teams := ""
+	if g.Teams != nil {
+		labels := make([]string, len(g.Teams))
+		for i, team := range g.Teams {
+			labels[i] = game.TeamNames[team-1]
+		}
+		teams = strings.Join(labels, ",")
+	}
+	series := sql.NullInt64{Int64: g.SeriesID, Valid: g.SeriesID != 0}
 
-	_, err := db.Exec(`
-		INSERT INTO games (player1, player2, winner, moves)
-		VALUES (?, ?, ?, ?)
-	`, player1, player2, winner, movesStr)
+	res, err := db.Exec(`
+		INSERT INTO games (player1, player2, winner, moves, players, placements, teams, layout, start_position,
+			state, result, reason, time_control, clocks, series_id, rated)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, g.PlayerName(1), g.PlayerName(2), g.Winner(), movesStr,
+		strings.Join(g.Players, ","), strings.Join(placements, ","), teams, g.Layout(), g.StartPosition,
+		g.State, g.Result, g.Reason, g.TimeControl(), clocks, series, g.Rated())
 
 	if err != nil {
 		log.Printf("Error saving game: %v", err)
+		return 0
 	}
+	id, _ := res.LastInsertId()
+	return id
 }
 
-// AddWin increases a player's score in DB
-func AddWin(username string) {
-	rankMutex.Lock()
-	defer rankMutex.Unlock()
-
-	_, err := db.Exec(`
-		INSERT INTO rankings (username, score)
-		VALUES (?, 1)
-		ON CONFLICT(username) DO UPDATE SET score = score + 1
-	`, username)
-
-	if err != nil {
-		log.Printf("Error updating score for %s: %v", username, err)
-	}
+// Standing is a player's place on the leaderboard.
+type Standing struct {
+	Username    string
+	Rating      int
+	Deviation   int
+	Games       int
+	Provisional bool
 }
 
-// GetRanking returns a sorted list of players by score
-func GetRanking() []struct {
-	Username string
-	Score    int
-} {
+// GetRanking returns every player ordered by rating, with players whose
+// rating is still provisional after the established ones.
+func GetRanking() []Standing {
 	rankMutex.Lock()
 	defer rankMutex.Unlock()
 
-	rows, err := db.Query(`SELECT username, score FROM rankings`)
+	rows, err := db.Query(`SELECT username, rated_games FROM rankings`)
 	if err != nil {
 		log.Printf("Error fetching rankings: %v", err)
 		return nil
 	}
-	defer rows.Close()
-
-	var ranking []struct {
-		Username string
-		Score    int
-	}
+	var ranking []Standing
 	for rows.Next() {
-		var user string
-		var score int
-		if err := rows.Scan(&user, &score); err != nil {
+		var st Standing
+		if err := rows.Scan(&st.Username, &st.Games); err != nil {
 			log.Println("Error scanning row:", err)
 			continue
 		}
-		ranking = append(ranking, struct {
-			Username string
-			Score    int
-		}{user, score})
+		ranking = append(ranking, st)
+	}
+	rows.Close()
+
+	for i := range ranking {
+		r := loadRating(ranking[i].Username) // with decay for inactive players
+		ranking[i].Rating = int(math.Round(r.Rating))
+		ranking[i].Deviation = int(math.Round(r.Deviation))
+		ranking[i].Provisional = r.Provisional()
 	}
 
-	// Sort by score desc, then username
+	// Sort established players first, then by rating desc, then username
 	sort.Slice(ranking, func(i, j int) bool {
-		if ranking[i].Score == ranking[j].Score {
+		if ranking[i].Provisional != ranking[j].Provisional {
+			return !ranking[i].Provisional
+		}
+		if ranking[i].Rating == ranking[j].Rating {
 			return ranking[i].Username < ranking[j].Username
 		}
-		return ranking[i].Score > ranking[j].Score
+		return ranking[i].Rating > ranking[j].Rating
 	})
 
 	return ranking
@@ -100,3 +126,71 @@ func HandleRanking(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(ranking)
 }
+
+// GameRecord is a finished game as returned by the API.
+type GameRecord struct {
+	ID          int64  `json:"id"`
+	Players     string `json:"players"`
+	Winner      string `json:"winner"`
+	State       string `json:"state"`
+	Result      string `json:"result"`
+	Reason      string `json:"reason"`
+	Moves       int    `json:"moves"`
+	TimeControl string `json:"time_control"`
+	Rated       bool   `json:"rated"`
+	SeriesID    int64  `json:"series_id,omitempty"`
+	Created     string `json:"created_at"`
+}
+
+// GetGames returns the most recent games, optionally only those a user
+// played in.
+func GetGames(username string, limit int) []GameRecord {
+	rankMutex.Lock()
+	defer rankMutex.Unlock()
+
+	rows, err := db.Query(`
+		SELECT id, IFNULL(players, player1 || ',' || player2), IFNULL(winner, ''), IFNULL(state, ''),
+			IFNULL(result, ''), IFNULL(reason, ''), IFNULL(moves, ''), IFNULL(time_control, ''), IFNULL(rated, 1),
+			IFNULL(series_id, 0), IFNULL(created_at, '')
+		FROM games
+		WHERE ? = '' OR ',' || IFNULL(players, player1 || ',' || player2) || ',' LIKE '%,' || ? || ',%'
+		ORDER BY id DESC LIMIT ?
+	`, username, username, limit)
+	if err != nil {
+		log.Printf("Error fetching games: %v", err)
+		return nil
+	}
+	defer rows.Close()
+
+	records := []GameRecord{}
+	for rows.Next() {
+		var rec GameRecord
+		var moves string
+		if err := rows.Scan(&rec.ID, &rec.Players, &rec.Winner, &rec.State, &rec.Result, &rec.Reason,
+			&moves, &rec.TimeControl, &rec.Rated, &rec.SeriesID, &rec.Created); err != nil {
+			log.Println("Error scanning row:", err)
+			continue
+		}
+		if moves != "" {
+			rec.Moves = len(strings.Split(moves, ","))
+		}
+		records = append(records, rec)
+	}
+	return records
+}
+
+// HandleGames lists recent games with their state, result and reason,
+// e.g. /api/games?username=alice&limit=20
+func HandleGames(w http.ResponseWriter, r *http.Request) {
+	limit := 50
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(GetGames(r.URL.Query().Get("username"), limit))
+}
@@ -0,0 +1,142 @@
+package matchmaking
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"Connect-4/internals/handlers/game"
+)
+
+const (
+	// A player who aborted more than this share of their games, once they
+	// have played abortMinGames, is a habitual aborter and loses
+	// abortRatingPenalty rating points for every further abort.
+	abortPenaltyRate   = 0.2
+	abortMinGames      = 10
+	abortRatingPenalty = 10
+)
+
+// abort calls the game off because the seat never made its first move in
+// time, or left before making it. Nobody wins, the rankings stay as they
+// are and the abort is held against that player.
+func (s *GameSession) abort(seat int, message string) {
+	s.abortedBy = seat
+	if s.cancel(message) {
+		recordAbort(s.Players[seat-1].Username)
+	}
+}
+
+// cancel aborts the game without holding it against anybody, reporting
+// whether the game was still on.
+func (s *GameSession) cancel(message string) bool {
+	g := s.Game
+	g.Mutex.Lock()
+	if g.Over() {
+		g.Mutex.Unlock()
+		return false
+	}
+	if err := g.Abort(game.ReasonAbort); err != nil {
+		g.Mutex.Unlock()
+		log.Printf("Game %s: %v", g.ID, err)
+		return false
+	}
+	g.Mutex.Unlock()
+
+	log.Printf("Game %s aborted: %s", g.ID, message)
+	finishGame(s, map[string]interface{}{"message": "Game aborted: " + message})
+	return true
+}
+
+// humanNames lists the players in the session who are not bots.
+func (s *GameSession) humanNames() []string {
+	names := []string{}
+	for _, p := range s.Players {
+		if !p.Bot {
+			names = append(names, p.Username)
+		}
+	}
+	return names
+}
+
+// recordGamesPlayed counts a game, finished or aborted, towards each
+// player's abort rate.
+func recordGamesPlayed(names []string) {
+	rankMutex.Lock()
+	defer rankMutex.Unlock()
+	for _, name := range names {
+		_, err := db.Exec(`
+			INSERT INTO abort_stats (username, games) VALUES (?, 1)
+			ON CONFLICT(username) DO UPDATE SET games = games + 1
+		`, name)
+		if err != nil {
+			log.Printf("Error counting game for %s: %v", name, err)
+		}
+	}
+}
+
+// recordAbort holds an aborted game against a player, and penalises them
+// if they abort habitually.
+func recordAbort(username string) {
+	rankMutex.Lock()
+	_, err := db.Exec(`
+		INSERT INTO abort_stats (username, aborts) VALUES (?, 1)
+		ON CONFLICT(username) DO UPDATE SET aborts = aborts + 1
+	`, username)
+	rankMutex.Unlock()
+	if err != nil {
+		log.Printf("Error recording abort for %s: %v", username, err)
+		return
+	}
+	if stats := GetAbortStats(username); stats.Habitual {
+		log.Printf("%s aborts habitually (%d of %d games), deducting %d rating points.", username, stats.Aborts, stats.Games, abortRatingPenalty)
+		penaliseRating(username, abortRatingPenalty)
+	}
+}
+
+// AbortStats is how often a player has aborted their games.
+type AbortStats struct {
+	Username string  `json:"username"`
+	Games    int     `json:"games"`
+	Aborts   int     `json:"aborts"`
+	Rate     float64 `json:"rate"`
+	Habitual bool    `json:"habitual"`
+}
+
+// GetAbortStats returns a player's abort record.
+func GetAbortStats(username string) AbortStats {
+	rankMutex.Lock()
+	defer rankMutex.Unlock()
+
+	stats := AbortStats{Username: username}
+	err := db.QueryRow(`SELECT games, aborts FROM abort_stats WHERE username = ?`, username).
+		Scan(&stats.Games, &stats.Aborts)
+	if err != nil {
+		return stats // no games yet
+	}
+	if stats.Games > 0 {
+		stats.Rate = float64(stats.Aborts) / float64(stats.Games)
+	}
+	stats.Habitual = stats.Games >= abortMinGames && stats.Rate > abortPenaltyRate
+	return stats
+}
+
+// HandleAbortStats returns a player's abort record, e.g. /api/aborts?username=alice
+func HandleAbortStats(w http.ResponseWriter, r *http.Request) {
+	username := r.URL.Query().Get("username")
+	if username == "" {
+		http.Error(w, "Username required", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(GetAbortStats(username))
+}
+
+// abortMessage explains why a game is aborted.
+func abortMessage(name string, left bool) string {
+	if left {
+		return fmt.Sprintf("%s left before making a move.", name)
+	}
+	return fmt.Sprintf("%s did not make a move within %v.", name, abortWindow)
+}
@@ -0,0 +1,676 @@
+package matchmaking
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"Connect-4/internals/handlers/game"
+)
+
+const (
+	arenaUpcoming = "upcoming"
+	arenaRunning  = "running"
+	arenaFinished = "finished"
+
+	// How long an arena lasts if its creator does not say, and at most
+	defaultArenaMinutes = 30
+	maxArenaMinutes     = 240
+
+	// Points for a win and a draw. After arenaFireStreak wins in a row a
+	// player is on fire and scores double until they fail to win.
+	arenaWinPoints  = 2
+	arenaDrawPoints = 1
+	arenaFireStreak = 2
+
+	// Number of players on the leaderboard sent after every game
+	arenaLeaderboardSize = 10
+)
+
+var (
+	// Arenas that are not over yet, by id
+	arenas      = make(map[int64]*Arena)
+	arenasMutex sync.Mutex // To protect arenas and everything in them
+)
+
+// Arena is a time-boxed tournament without rounds: players who finish a
+// game are paired again right away with someone close in score, until
+// the time is up.
+type Arena struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	Settings  QueueInfo `json:"settings"`
+	Minutes   int       `json:"minutes"`
+	Status    string    `json:"status"` // upcoming, running or finished
+	CreatedBy string    `json:"created_by"`
+	Starts    time.Time `json:"starts_at"`
+	Ends      time.Time `json:"ends_at"`
+	Winner    string    `json:"winner,omitempty"`
+
+	opts      GameOptions
+	pool      *pool // the arena's own pool, apart from the usual queues
+	standings map[string]*ArenaStanding
+	players   map[string]*Player // the /ws/game connection of each player who joined
+}
+
+// ArenaStanding is one player's place on an arena's leaderboard.
+type ArenaStanding struct {
+	Rank     int    `json:"rank"`
+	Username string `json:"username"`
+	Score    int    `json:"score"`
+	Games    int    `json:"games"`
+	Wins     int    `json:"wins"`
+	Draws    int    `json:"draws"`
+	Losses   int    `json:"losses"`
+	Streak   int    `json:"streak"`  // wins in a row
+	OnFire   bool   `json:"on_fire"` // scoring double for now
+
+	last string // the opponent in the player's last game
+}
+
+// add scores one game, 1 for a win, 0.5 for a draw and 0 for a loss, and
+// returns the points it earned.
+func (st *ArenaStanding) add(result float64) int {
+	points := 0
+	switch result {
+	case 1:
+		points = arenaWinPoints
+	case 0.5:
+		points = arenaDrawPoints
+	}
+	if st.OnFire {
+		points *= 2
+	}
+	st.Games++
+	st.Score += points
+	switch result {
+	case 1:
+		st.Wins++
+		st.Streak++
+	case 0.5:
+		st.Draws++
+		st.Streak = 0
+	default:
+		st.Losses++
+		st.Streak = 0
+	}
+	st.OnFire = st.Streak >= arenaFireStreak
+	return points
+}
+
+// rankArena sorts a leaderboard by score, then by wins and then by fewer
+// games played, and numbers the places.
+func rankArena(list []ArenaStanding) {
+	sort.SliceStable(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		switch {
+		case a.Score != b.Score:
+			return a.Score > b.Score
+		case a.Wins != b.Wins:
+			return a.Wins > b.Wins
+		case a.Games != b.Games:
+			return a.Games < b.Games
+		}
+		return a.Username < b.Username
+	})
+	for i := range list {
+		list[i].Rank = i + 1
+	}
+}
+
+// createArena schedules an arena. Arena games are one against one with
+// the given settings.
+func createArena(creator, name string, minutes int, starts time.Time, opts GameOptions) (*Arena, error) {
+	if opts.Seats != minSeats || opts.Teams || opts.BestOf > 0 {
+		return nil, errors.New("arena games are single games between two players")
+	}
+	if opts.Position != "" {
+		return nil, errors.New("arena games start from the empty board")
+	}
+	if minutes == 0 {
+		minutes = defaultArenaMinutes
+	} else if minutes < 1 || minutes > maxArenaMinutes {
+		return nil, fmt.Errorf("minutes must be between 1 and %d", maxArenaMinutes)
+	}
+	if starts.IsZero() {
+		starts = time.Now().Add(tournamentDelay)
+	} else if starts.Before(time.Now()) {
+		return nil, errors.New("the start time has already passed")
+	}
+	if name == "" {
+		name = fmt.Sprintf("%s's arena", creator)
+	}
+
+	a := &Arena{
+		Name:      name,
+		Settings:  queueInfo(opts),
+		Minutes:   minutes,
+		Status:    arenaUpcoming,
+		CreatedBy: creator,
+		Starts:    starts.Truncate(time.Second),
+		standings: make(map[string]*ArenaStanding),
+		players:   make(map[string]*Player),
+	}
+	a.Ends = a.Starts.Add(time.Duration(minutes) * time.Minute)
+	if err := saveArena(a); err != nil {
+		return nil, err
+	}
+	a.setOptions(opts)
+	arenasMutex.Lock()
+	arenas[a.ID] = a
+	arenasMutex.Unlock()
+	time.AfterFunc(time.Until(a.Starts), a.start)
+	log.Printf("%s created arena %d, running %d minutes from %s.", creator, a.ID, minutes, a.Starts.Format(time.RFC3339))
+	return a, nil
+}
+
+// setOptions gives the arena its game settings and a pool of its own, see
+// pool.join.
+func (a *Arena) setOptions(opts GameOptions) {
+	opts.arena = a
+	a.opts = opts
+	a.pool = newPool(opts)
+}
+
+// arenaFromRequest returns the arena a player connecting to /ws/game plays
+// in, e.g. arena=4, or nil when they did not ask for one.
+func arenaFromRequest(r *http.Request) (*Arena, error) {
+	id := r.URL.Query().Get("arena")
+	if id == "" {
+		return nil, nil
+	}
+	n, err := strconv.ParseInt(id, 10, 64)
+	arenasMutex.Lock()
+	a := arenas[n]
+	arenasMutex.Unlock()
+	if err != nil || a == nil {
+		return nil, errors.New("that arena is over or does not exist")
+	}
+	return a, nil
+}
+
+// leaderboard ranks everybody who joined. Must be called with arenasMutex
+// held.
+func (a *Arena) leaderboard() []ArenaStanding {
+	list := make([]ArenaStanding, 0, len(a.standings))
+	for _, st := range a.standings {
+		list = append(list, *st)
+	}
+	rankArena(list)
+	return list
+}
+
+// broadcast writes a message to every player connected to the arena.
+// Must be called with arenasMutex held.
+func (a *Arena) broadcast(msg map[string]interface{}) {
+	for _, p := range a.players {
+		p.Send(msg)
+	}
+}
+
+// matching returns what the arena's pool matches a player on: their
+// score, and the last opponent they should not meet again right away.
+func (a *Arena) matching(name string) (int, string) {
+	arenasMutex.Lock()
+	defer arenasMutex.Unlock()
+	if st := a.standings[name]; st != nil {
+		return st.Score, st.last
+	}
+	return 0, ""
+}
+
+// enter connects a player's /ws/game socket to the arena, putting them on
+// the leaderboard the first time. While the arena runs they go straight
+// into its pool; before that they wait for the start.
+func (a *Arena) enter(p *Player) {
+	arenasMutex.Lock()
+	if a.Status == arenaFinished {
+		arenasMutex.Unlock()
+		p.Send(map[string]interface{}{"type": "ARENA_CLOSED", "message": "The arena is over."})
+		p.Conn.Close()
+		return
+	}
+	if other := a.players[p.Username]; other != nil && other.connected() && !other.hasLeft() {
+		arenasMutex.Unlock()
+		p.Send(map[string]interface{}{"type": "ARENA_CLOSED", "message": "You are already playing in this arena."})
+		p.Conn.Close()
+		return
+	}
+	st := a.standings[p.Username]
+	if st == nil {
+		st = &ArenaStanding{Username: p.Username}
+		a.standings[p.Username] = st
+		saveArenaScore(a, st)
+		log.Printf("%s joined arena %d.", p.Username, a.ID)
+	}
+	a.players[p.Username] = p
+	running := a.Status == arenaRunning
+	msg := map[string]interface{}{
+		"type":     "ARENA_JOINED",
+		"message":  fmt.Sprintf("Waiting for %s to start.", a.Name),
+		"arena":    *a,
+		"standing": *st,
+	}
+	if running {
+		msg["message"] = "Looking for your next opponent..."
+	}
+	arenasMutex.Unlock()
+
+	p.Send(msg)
+	if running {
+		a.queue(p)
+	}
+}
+
+// queue puts a player in the arena's pool for their next game.
+func (a *Arena) queue(p *Player) {
+	p.setSession(nil)
+	q := a.pool
+	q.join(p)
+
+	// The player may have gone, or the arena ended, while they were queued
+	arenasMutex.Lock()
+	over := a.Status != arenaRunning
+	arenasMutex.Unlock()
+	if over || !p.connected() || p.hasLeft() {
+		q.remove(p)
+	}
+}
+
+// start opens the arena, pairing everybody already waiting, and ends it
+// once the time is up.
+func (a *Arena) start() {
+	arenasMutex.Lock()
+	if a.Status != arenaUpcoming {
+		arenasMutex.Unlock()
+		return
+	}
+	a.Status = arenaRunning
+	updateArena(a)
+	log.Printf("Arena %d started.", a.ID)
+	var ready []*Player
+	for _, p := range a.players {
+		if p.connected() && !p.hasLeft() {
+			ready = append(ready, p)
+		}
+	}
+	a.broadcast(map[string]interface{}{
+		"type":    "ARENA_START",
+		"message": fmt.Sprintf("%s has started! It ends in %d minutes.", a.Name, a.Minutes),
+		"arena":   *a,
+	})
+	arenasMutex.Unlock()
+
+	time.AfterFunc(time.Until(a.Ends), a.end)
+	for _, p := range ready {
+		a.queue(p)
+	}
+}
+
+// end closes the arena once the time is up. Its pool stops, and games
+// still being played no longer count.
+func (a *Arena) end() {
+	arenasMutex.Lock()
+	a.Status = arenaFinished
+	board := a.leaderboard()
+	if len(board) > 0 && board[0].Games > 0 {
+		a.Winner = board[0].Username
+	}
+	updateArena(a)
+	delete(arenas, a.ID)
+	players := make([]*Player, 0, len(a.players))
+	for _, p := range a.players {
+		players = append(players, p)
+	}
+	msg := map[string]interface{}{
+		"type":        "ARENA_OVER",
+		"message":     fmt.Sprintf("%s is over.", a.Name),
+		"winner":      a.Winner,
+		"leaderboard": board,
+	}
+	if a.Winner != "" {
+		msg["message"] = fmt.Sprintf("%s wins %s!", a.Winner, a.Name)
+	}
+	arenasMutex.Unlock()
+
+	close(a.pool.stop)
+	for _, p := range players {
+		a.pool.remove(p)
+		p.Send(msg)
+	}
+	log.Printf("Arena %d over, %s wins.", a.ID, a.Winner)
+}
+
+// record scores a finished arena game, tells both players what it earned
+// them and everybody the new leaderboard, and puts the players back in
+// the pool. Aborted games score nothing.
+func (a *Arena) record(s *GameSession) {
+	g := s.Game
+	g.Mutex.Lock()
+	state := g.State
+	places := append([]int{}, g.Places...)
+	g.Mutex.Unlock()
+
+	arenasMutex.Lock()
+	if a.Status != arenaRunning {
+		arenasMutex.Unlock()
+		return
+	}
+	for i, p := range s.Players {
+		if st := a.standings[p.Username]; st != nil {
+			st.last = s.Players[1-i].Username
+		}
+	}
+	if state == game.StateFinished {
+		points := make([]int, len(s.Players))
+		for i, p := range s.Players {
+			result := 0.5
+			if places[i] < places[1-i] {
+				result = 1
+			} else if places[i] > places[1-i] {
+				result = 0
+			}
+			if st := a.standings[p.Username]; st != nil {
+				points[i] = st.add(result)
+				saveArenaScore(a, st)
+			}
+		}
+		board := a.leaderboard()
+		for i, p := range s.Players {
+			for _, st := range board {
+				if st.Username != p.Username {
+					continue
+				}
+				msg := map[string]interface{}{
+					"type":     "ARENA_SCORE",
+					"message":  fmt.Sprintf("+%d points.", points[i]),
+					"points":   points[i],
+					"standing": st,
+				}
+				if st.OnFire {
+					msg["message"] = fmt.Sprintf("+%d points. %d wins in a row: you are on fire and score double!", points[i], st.Streak)
+				}
+				p.Send(msg)
+			}
+		}
+		top := board
+		if len(top) > arenaLeaderboardSize {
+			top = top[:arenaLeaderboardSize]
+		}
+		a.broadcast(map[string]interface{}{
+			"type":        "ARENA_LEADERBOARD",
+			"leaderboard": top,
+			"players":     len(board),
+			"ends_at":     a.Ends,
+		})
+	}
+	var back []*Player
+	for _, p := range s.Players {
+		if a.players[p.Username] == p && p.connected() {
+			back = append(back, p)
+		}
+	}
+	arenasMutex.Unlock()
+
+	for _, p := range back {
+		a.queue(p)
+	}
+}
+
+// saveArena stores a new arena and sets its id.
+func saveArena(a *Arena) error {
+	settings, _ := json.Marshal(a.Settings)
+	rankMutex.Lock()
+	defer rankMutex.Unlock()
+	res, err := db.Exec(`
+		INSERT INTO arenas (name, settings, minutes, status, created_by, starts_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, a.Name, string(settings), a.Minutes, a.Status, a.CreatedBy, a.Starts.Unix())
+	if err != nil {
+		log.Printf("Error saving arena: %v", err)
+		return errors.New("the arena could not be saved")
+	}
+	a.ID, _ = res.LastInsertId()
+	return nil
+}
+
+// updateArena stores an arena's status and winner.
+func updateArena(a *Arena) {
+	rankMutex.Lock()
+	defer rankMutex.Unlock()
+	_, err := db.Exec(`UPDATE arenas SET status = ?, winner = NULLIF(?, '') WHERE id = ?`, a.Status, a.Winner, a.ID)
+	if err != nil {
+		log.Printf("Error updating arena %d: %v", a.ID, err)
+	}
+}
+
+// saveArenaScore stores a player's score in an arena.
+func saveArenaScore(a *Arena, st *ArenaStanding) {
+	rankMutex.Lock()
+	defer rankMutex.Unlock()
+	_, err := db.Exec(`
+		INSERT INTO arena_scores (arena_id, username, score, games, wins, draws, losses, streak)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(arena_id, username) DO UPDATE SET score = excluded.score, games = excluded.games,
+			wins = excluded.wins, draws = excluded.draws, losses = excluded.losses, streak = excluded.streak
+	`, a.ID, st.Username, st.Score, st.Games, st.Wins, st.Draws, st.Losses, st.Streak)
+	if err != nil {
+		log.Printf("Error saving %s in arena %d: %v", st.Username, a.ID, err)
+	}
+}
+
+const arenaColumns = `id, name, settings, minutes, status, created_by, starts_at, IFNULL(winner, '')`
+
+// scanArena reads an arena row.
+func scanArena(row interface{ Scan(...interface{}) error }) (*Arena, error) {
+	a := &Arena{}
+	var settings string
+	var starts int64
+	err := row.Scan(&a.ID, &a.Name, &settings, &a.Minutes, &a.Status, &a.CreatedBy, &starts, &a.Winner)
+	if err != nil {
+		return nil, err
+	}
+	json.Unmarshal([]byte(settings), &a.Settings)
+	a.Starts = time.Unix(starts, 0)
+	a.Ends = a.Starts.Add(time.Duration(a.Minutes) * time.Minute)
+	return a, nil
+}
+
+// GetArenas returns the most recent arenas, optionally only those with
+// the given status.
+func GetArenas(status string, limit int) []Arena {
+	rankMutex.Lock()
+	defer rankMutex.Unlock()
+
+	rows, err := db.Query(`
+		SELECT `+arenaColumns+` FROM arenas
+		WHERE ? = '' OR status = ?
+		ORDER BY id DESC LIMIT ?
+	`, status, status, limit)
+	if err != nil {
+		log.Printf("Error fetching arenas: %v", err)
+		return nil
+	}
+	defer rows.Close()
+
+	list := []Arena{}
+	for rows.Next() {
+		a, err := scanArena(rows)
+		if err != nil {
+			log.Println("Error scanning row:", err)
+			continue
+		}
+		list = append(list, *a)
+	}
+	return list
+}
+
+// GetArenaLeaderboard returns an arena with the ranking of every player
+// who joined it.
+func GetArenaLeaderboard(id int64) (*Arena, []ArenaStanding, error) {
+	rankMutex.Lock()
+	defer rankMutex.Unlock()
+	a, err := scanArena(db.QueryRow(`SELECT `+arenaColumns+` FROM arenas WHERE id = ?`, id))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rows, err := db.Query(`
+		SELECT username, score, games, wins, draws, losses, streak FROM arena_scores WHERE arena_id = ?
+	`, id)
+	if err != nil {
+		log.Printf("Error fetching scores of arena %d: %v", id, err)
+		return a, []ArenaStanding{}, nil
+	}
+	defer rows.Close()
+	board := []ArenaStanding{}
+	for rows.Next() {
+		var st ArenaStanding
+		if err := rows.Scan(&st.Username, &st.Score, &st.Games, &st.Wins, &st.Draws, &st.Losses, &st.Streak); err != nil {
+			log.Println("Error scanning row:", err)
+			continue
+		}
+		st.OnFire = st.Streak >= arenaFireStreak
+		board = append(board, st)
+	}
+	rankArena(board)
+	return a, board, nil
+}
+
+// ResumeArenas picks up the arenas of the previous run when the server
+// starts, with the scores so far. Players get back in by connecting again;
+// an arena whose time ran out meanwhile ends right away.
+func ResumeArenas() {
+	rankMutex.Lock()
+	rows, err := db.Query(`SELECT id FROM arenas WHERE status IN (?, ?)`, arenaUpcoming, arenaRunning)
+	if err != nil {
+		rankMutex.Unlock()
+		log.Printf("Error fetching unfinished arenas: %v", err)
+		return
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			log.Println("Error scanning row:", err)
+			continue
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	rankMutex.Unlock()
+
+	for _, id := range ids {
+		a, board, err := GetArenaLeaderboard(id)
+		if err != nil {
+			log.Printf("Error fetching arena %d: %v", id, err)
+			continue
+		}
+		a.standings = make(map[string]*ArenaStanding)
+		a.players = make(map[string]*Player)
+		for i := range board {
+			a.standings[board[i].Username] = &board[i]
+		}
+		a.setOptions(queueOptions(a.Settings))
+		arenasMutex.Lock()
+		arenas[a.ID] = a
+		arenasMutex.Unlock()
+		if a.Status == arenaRunning {
+			time.AfterFunc(time.Until(a.Ends), a.end)
+		} else {
+			time.AfterFunc(time.Until(a.Starts), a.start)
+		}
+		log.Printf("Arena %d resumed (%s).", a.ID, a.Status)
+	}
+}
+
+// HandleArenas lists arenas (GET), newest first, e.g.
+// /api/arenas?status=running&limit=20, or creates one (POST) with the same
+// game settings as /ws/game, e.g. POST /api/arenas?username=alice
+// &minutes=45&time=3%2B0&start=2026-05-01T18:00:00Z. Anybody can create an
+// arena; it starts in five minutes unless start is given.
+func HandleArenas(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if r.Method != http.MethodPost {
+		limit := 50
+		if v := q.Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(GetArenas(q.Get("status"), limit))
+		return
+	}
+
+	username := q.Get("username")
+	if username == "" {
+		http.Error(w, "Username required", http.StatusBadRequest)
+		return
+	}
+	opts, err := parseGameOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	minutes := 0
+	if v := q.Get("minutes"); v != "" {
+		minutes, err = strconv.Atoi(v)
+		if err != nil || minutes < 1 {
+			http.Error(w, "minutes must be a positive number", http.StatusBadRequest)
+			return
+		}
+	}
+	var starts time.Time
+	if v := q.Get("start"); v != "" {
+		starts, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			http.Error(w, "start must be a time like 2026-05-01T18:00:00Z", http.StatusBadRequest)
+			return
+		}
+	}
+	a, err := createArena(username, q.Get("name"), minutes, starts, opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	arenasMutex.Lock()
+	view := *a
+	arenasMutex.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(view)
+}
+
+// HandleArenaLeaderboard returns the live leaderboard of an arena, e.g.
+// /api/arenas/leaderboard?id=4
+func HandleArenaLeaderboard(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Unknown arena", http.StatusNotFound)
+		return
+	}
+	a, board, err := GetArenaLeaderboard(id)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Error fetching arena %d: %v", id, err)
+		}
+		http.Error(w, "Unknown arena", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"arena":       a,
+		"leaderboard": board,
+	})
+}
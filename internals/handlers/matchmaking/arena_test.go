@@ -0,0 +1,126 @@
+package matchmaking
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"Connect-4/internals/handlers/game"
+)
+
+// arenaWaiter is a player waiting in an arena's pool: their score, the
+// opponent of their last game and how long they have been waiting.
+type arenaWaiter struct {
+	name   string
+	score  float64
+	last   string
+	waited time.Duration
+}
+
+func TestArenaPoolMatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		waiting []arenaWaiter
+		want    [][2]string
+	}{
+		{
+			name: "closest scores are paired",
+			waiting: []arenaWaiter{
+				{name: "a", score: 0, waited: 3 * time.Second},
+				{name: "b", score: 40, waited: 2 * time.Second},
+				{name: "c", score: 44, waited: time.Second},
+				{name: "d", score: 6},
+			},
+			want: [][2]string{{"a", "d"}, {"b", "c"}},
+		},
+		{
+			name: "last opponents wait before meeting again",
+			waiting: []arenaWaiter{
+				{name: "a", last: "b", waited: arenaRematchWait / 2},
+				{name: "b", last: "a", waited: arenaRematchWait},
+			},
+		},
+		{
+			name: "last opponents meet again once both have waited",
+			waiting: []arenaWaiter{
+				{name: "a", last: "b", waited: arenaRematchWait},
+				{name: "b", last: "a", waited: arenaRematchWait},
+			},
+			want: [][2]string{{"a", "b"}},
+		},
+		{
+			name: "someone new is preferred over the last opponent",
+			waiting: []arenaWaiter{
+				{name: "a", last: "b", waited: time.Second},
+				{name: "b", last: "a", waited: time.Second},
+				{name: "c", score: 50},
+			},
+			want: [][2]string{{"a", "c"}},
+		},
+		{
+			name: "far apart scores wait for the window to grow",
+			waiting: []arenaWaiter{
+				{name: "a", score: 0, waited: time.Second},
+				{name: "b", score: 200},
+			},
+		},
+		{
+			name: "nobody is given a bot",
+			waiting: []arenaWaiter{
+				{name: "a", waited: time.Hour},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Now()
+			q := &pool{opts: GameOptions{Seats: 2, arena: &Arena{}}}
+			for _, w := range tt.waiting {
+				q.waiting = append(q.waiting, &poolEntry{
+					player: &Player{Username: w.name},
+					rating: w.score,
+					avoid:  w.last,
+					joined: now.Add(-w.waited),
+				})
+			}
+
+			var got [][2]string
+			for _, players := range q.match(now) {
+				got = append(got, [2]string{players[0].Username, players[1].Username})
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("match() = %v, want %v", got, tt.want)
+			}
+			if left := len(tt.waiting) - 2*len(got); len(q.waiting) != left {
+				t.Errorf("%d players still waiting, want %d", len(q.waiting), left)
+			}
+		})
+	}
+}
+
+func TestArenaRecordRemembersOpponent(t *testing.T) {
+	a := &Arena{
+		Status: arenaRunning,
+		standings: map[string]*ArenaStanding{
+			"alice": {Username: "alice", Score: 4, last: "carol"},
+			"bob":   {Username: "bob", Score: 2},
+		},
+		players: make(map[string]*Player),
+	}
+	g := game.NewGame("arena", "alice", "bob")
+	g.Abort(game.ReasonAbort)
+	a.record(&GameSession{Game: g, Players: []*Player{{Username: "alice", ID: 1}, {Username: "bob", ID: 2}}})
+
+	for name, want := range map[string]string{"alice": "bob", "bob": "alice"} {
+		score, last := a.matching(name)
+		if last != want {
+			t.Errorf("%s's last opponent = %q, want %q", name, last, want)
+		}
+		if score != a.standings[name].Score || a.standings[name].Games != 0 {
+			t.Errorf("an aborted game changed %s's standing to %+v", name, a.standings[name])
+		}
+	}
+	if score, last := a.matching("dave"); score != 0 || last != "" {
+		t.Errorf("matching(dave) = %d, %q for a player not in the arena", score, last)
+	}
+}
@@ -0,0 +1,443 @@
+package matchmaking
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// Invite codes are made of characters that are hard to mix up
+	inviteAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+	inviteLength   = 8
+)
+
+var (
+	// Open challenges and private rooms by invite code
+	challenges       = make(map[string]*Challenge)
+	challengesMutex  sync.Mutex // To protect challenges and every challenge in it
+	challengeTimeout = 5 * time.Minute
+)
+
+// Challenge is a game offered to one player, or in a private room to
+// whoever has the invite code. Both sides connect to /ws/game with
+// join=<code> (the challenger may instead create it there), and the game
+// starts once it is accepted and both are connected.
+type Challenge struct {
+	Code     string    `json:"code"`
+	From     string    `json:"from"`
+	To       string    `json:"to,omitempty"` // empty for a private room until somebody accepts
+	Room     bool      `json:"room"`
+	Seek     bool      `json:"seek"` // an open seek anybody in the lobby can pick
+	Queue    QueueInfo `json:"settings"`
+	Colour   string    `json:"colour"` // the challenger's seat: "first", "second" or "random"
+	Accepted bool      `json:"accepted"`
+	Expires  time.Time `json:"expires_at"`
+
+	opts    GameOptions
+	players map[string]*Player // connected sides
+	timer   *time.Timer
+}
+
+// newInviteCode makes an invite code that is not in use. Must be called
+// with challengesMutex held.
+func newInviteCode() string {
+	for {
+		code := make([]byte, inviteLength)
+		for i := range code {
+			code[i] = inviteAlphabet[rand.Intn(len(inviteAlphabet))]
+		}
+		if _, taken := challenges[string(code)]; !taken {
+			return string(code)
+		}
+	}
+}
+
+// createChallenge offers a game with the given options from one player to
+// another, or opens a private room when to is empty. A seek is offered to
+// everybody in the lobby instead.
+func createChallenge(from, to string, seek bool, opts GameOptions, colour string) (*Challenge, error) {
+	c, err := newChallenge(from, to, seek, opts, colour)
+	if err != nil {
+		return nil, err
+	}
+	c.open()
+	return c, nil
+}
+
+// newChallenge checks and sets up a challenge without offering it yet;
+// open does that.
+func newChallenge(from, to string, seek bool, opts GameOptions, colour string) (*Challenge, error) {
+	if opts.Seats != 2 || opts.Teams {
+		return nil, errors.New("challenges are only available in two-player games")
+	}
+	if to == from {
+		return nil, errors.New("you cannot challenge yourself")
+	}
+	switch colour {
+	case "":
+		colour = "random"
+	case "first", "second", "random":
+	default:
+		return nil, fmt.Errorf("colour must be first, second or random")
+	}
+
+	return &Challenge{
+		From:    from,
+		To:      to,
+		Room:    to == "" && !seek,
+		Seek:    seek,
+		Queue:   queueInfo(opts),
+		Colour:  colour,
+		Expires: time.Now().Add(challengeTimeout),
+		opts:    opts,
+		players: make(map[string]*Player),
+	}, nil
+}
+
+// open gives a new challenge its invite code and offers it, announcing a
+// seek in the lobby. It expires after challengeTimeout.
+func (c *Challenge) open() {
+	challengesMutex.Lock()
+	defer challengesMutex.Unlock()
+	c.Code = newInviteCode()
+	c.timer = time.AfterFunc(challengeTimeout, func() {
+		c.close("CHALLENGE_EXPIRED", "The challenge expired.")
+	})
+	challenges[c.Code] = c
+	switch {
+	case c.Seek:
+		log.Printf("%s posted seek %s.", c.From, c.Code)
+		announceSeek("SEEK_CREATED", c)
+	case c.Room:
+		log.Printf("%s opened private room %s.", c.From, c.Code)
+	default:
+		log.Printf("%s challenged %s (%s).", c.From, c.To, c.Code)
+	}
+}
+
+// findChallenge returns the open challenge with the given invite code.
+func findChallenge(code string) *Challenge {
+	challengesMutex.Lock()
+	defer challengesMutex.Unlock()
+	return challenges[code]
+}
+
+// mayJoin reports whether a player is one of the sides of the challenge,
+// or could become one. Must be called with challengesMutex held.
+func (c *Challenge) mayJoin(username string) bool {
+	return username == c.From || username == c.To || (c.To == "" && (c.Room || c.Seek))
+}
+
+// message describes the challenge for its sides.
+func (c *Challenge) message(typ, text string) map[string]interface{} {
+	return map[string]interface{}{
+		"type":      typ,
+		"message":   text,
+		"challenge": *c,
+	}
+}
+
+// attach connects a player's socket to the challenge and starts the game
+// if everything is in place.
+func (c *Challenge) attach(p *Player) {
+	challengesMutex.Lock()
+	if challenges[c.Code] != c || !c.mayJoin(p.Username) {
+		challengesMutex.Unlock()
+		p.Send(map[string]interface{}{"type": "CHALLENGE_ERROR", "message": "That challenge is no longer open."})
+		p.Conn.Close()
+		return
+	}
+	if old := c.players[p.Username]; old != nil && old != p {
+		old.Conn.Close() // the newer socket wins
+	}
+	if c.Seek && p.Username != c.From && !c.Accepted {
+		c.take(p.Username) // picking a seek accepts it
+	}
+	c.players[p.Username] = p
+	p.writeMu.Lock()
+	p.challenge = c
+	p.writeMu.Unlock()
+
+	msg := c.message("CHALLENGE", fmt.Sprintf("%s challenges you. Send CHALLENGE_ACCEPT or CHALLENGE_DECLINE.", c.From))
+	if p.Username == c.From {
+		msg = c.message("CHALLENGE_CREATED", "Waiting for your opponent...")
+		if c.Room {
+			msg["message"] = fmt.Sprintf("Share the invite code %s with your opponent.", c.Code)
+		}
+	} else if c.Accepted {
+		msg = c.message("CHALLENGE_ACCEPTED", "Waiting for your opponent...")
+	}
+	players := c.ready()
+	challengesMutex.Unlock()
+
+	p.Send(msg)
+	if players != nil {
+		go startGame(players, c.opts)
+	}
+}
+
+// ready takes an accepted challenge with both sides connected off the
+// board and returns its players in seat order. It returns nil while the
+// challenge is still waiting. Must be called with challengesMutex held.
+func (c *Challenge) ready() []*Player {
+	challenger, opponent := c.players[c.From], c.players[c.To]
+	if !c.Accepted || challenger == nil || opponent == nil {
+		return nil
+	}
+	delete(challenges, c.Code)
+	c.timer.Stop()
+	log.Printf("Challenge %s accepted: %s vs %s.", c.Code, c.From, c.To)
+
+	// Anybody else who came into a private room missed out
+	for name, p := range c.players {
+		if name != c.From && name != c.To {
+			go func(p *Player) {
+				p.Send(map[string]interface{}{"type": "CHALLENGE_ERROR", "message": "Somebody else took the seat."})
+				p.Conn.Close()
+			}(p)
+		}
+	}
+
+	first := c.Colour == "first" || (c.Colour == "random" && rand.Intn(2) == 0)
+	if first {
+		return []*Player{challenger, opponent}
+	}
+	return []*Player{opponent, challenger}
+}
+
+// accept takes the challenge up on behalf of a player.
+func (c *Challenge) accept(username string) error {
+	challengesMutex.Lock()
+	if challenges[c.Code] != c {
+		challengesMutex.Unlock()
+		return errors.New("that challenge is no longer open")
+	}
+	if username == c.From || !c.mayJoin(username) {
+		challengesMutex.Unlock()
+		return errors.New("that challenge is not for you")
+	}
+	c.take(username)
+	connected := make([]*Player, 0, len(c.players))
+	for _, p := range c.players {
+		connected = append(connected, p)
+	}
+	msg := c.message("CHALLENGE_ACCEPTED", fmt.Sprintf("%s accepted the challenge.", username))
+	players := c.ready()
+	challengesMutex.Unlock()
+
+	if players != nil {
+		go startGame(players, c.opts)
+		return nil
+	}
+	for _, p := range connected {
+		p.Send(msg)
+	}
+	return nil
+}
+
+// take accepts the challenge for a player, who claims it if it was a
+// private room or seek. Must be called with challengesMutex held.
+func (c *Challenge) take(username string) {
+	c.To = username
+	c.Accepted = true
+	if c.Seek {
+		announceSeek("SEEK_ACCEPTED", c)
+	}
+}
+
+// decline turns the challenge down, or withdraws it when the challenger
+// declines.
+func (c *Challenge) decline(username string) error {
+	challengesMutex.Lock()
+	mine := username == c.From || username == c.To
+	challengesMutex.Unlock()
+	if !mine {
+		return errors.New("that challenge is not for you")
+	}
+	if username == c.From {
+		c.close("CHALLENGE_CANCELLED", fmt.Sprintf("%s withdrew the challenge.", username))
+	} else {
+		c.close("CHALLENGE_DECLINED", fmt.Sprintf("%s declined the challenge.", username))
+	}
+	return nil
+}
+
+// leave handles a side's socket closing before the game started. The
+// challenger leaving withdraws the challenge; the other side may connect
+// again until it expires.
+func (c *Challenge) leave(p *Player) {
+	challengesMutex.Lock()
+	current := c.players[p.Username] == p // not replaced by a newer socket
+	if current {
+		delete(c.players, p.Username)
+	}
+	challenger := current && p.Username == c.From
+	challengesMutex.Unlock()
+	if challenger {
+		c.close("CHALLENGE_CANCELLED", fmt.Sprintf("%s left.", p.Username))
+	}
+}
+
+// close takes the challenge off the board, tells the connected sides why
+// and closes their sockets.
+func (c *Challenge) close(typ, text string) {
+	challengesMutex.Lock()
+	if challenges[c.Code] != c {
+		challengesMutex.Unlock()
+		return
+	}
+	delete(challenges, c.Code)
+	c.timer.Stop()
+	if c.Seek && !c.Accepted {
+		announceSeek("SEEK_CANCELLED", c)
+	}
+	msg := c.message(typ, text)
+	connected := make([]*Player, 0, len(c.players))
+	for _, p := range c.players {
+		connected = append(connected, p)
+	}
+	challengesMutex.Unlock()
+
+	log.Printf("Challenge %s closed: %s", c.Code, text)
+	for _, p := range connected {
+		p.Send(msg)
+		p.Conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+		p.Conn.Close()
+	}
+}
+
+// answer handles what a player connected to a challenge sends
+// before the game starts.
+func (c *Challenge) answer(p *Player, typ string) {
+	var err error
+	switch typ {
+	case "CHALLENGE_ACCEPT":
+		err = c.accept(p.Username)
+	case "CHALLENGE_DECLINE", "CHALLENGE_CANCEL":
+		err = c.decline(p.Username)
+	default:
+		return
+	}
+	if err != nil {
+		p.Send(map[string]interface{}{"type": "CHALLENGE_ERROR", "message": err.Error()})
+	}
+}
+
+// challengeFromRequest sets up or looks up the challenge a /ws/game
+// request asks for: challenge=<user> challenges a player, room=true opens
+// a private room, seek=true posts an open seek to the lobby and
+// join=<code> joins any of them. A new challenge is not offered until
+// open is called, once the challenger is connected. It returns nil for a
+// request that is not about a challenge.
+func challengeFromRequest(username string, opts GameOptions, r *http.Request) (*Challenge, error) {
+	q := r.URL.Query()
+	if code := q.Get("join"); code != "" {
+		c := findChallenge(code)
+		if c == nil {
+			return nil, errors.New("that challenge is no longer open")
+		}
+		challengesMutex.Lock()
+		ok := c.mayJoin(username)
+		challengesMutex.Unlock()
+		if !ok {
+			return nil, errors.New("that challenge is not for you")
+		}
+		return c, nil
+	}
+	if to := q.Get("challenge"); to != "" {
+		return newChallenge(username, to, false, opts, q.Get("colour"))
+	}
+	if q.Get("room") == "true" {
+		return newChallenge(username, "", false, opts, q.Get("colour"))
+	}
+	if q.Get("seek") == "true" {
+		return newChallenge(username, "", true, opts, q.Get("colour"))
+	}
+	return nil, nil
+}
+
+// GetChallenges lists the open challenges sent by or to a player, oldest
+// first. Private rooms are only listed for whoever opened them.
+func GetChallenges(username string) []Challenge {
+	challengesMutex.Lock()
+	defer challengesMutex.Unlock()
+	list := []Challenge{}
+	for _, c := range challenges {
+		if c.From == username || (c.To == username && !c.Room) {
+			list = append(list, *c)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Expires.Before(list[j].Expires) })
+	return list
+}
+
+// HandleChallenges lists a player's open challenges (GET) or creates one
+// (POST) with the same settings as /ws/game, e.g.
+// POST /api/challenges?username=alice&to=bob&time=5%2B3&colour=first
+// Leave out to for a private room.
+func HandleChallenges(w http.ResponseWriter, r *http.Request) {
+	username := r.URL.Query().Get("username")
+	if username == "" {
+		http.Error(w, "Username required", http.StatusBadRequest)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(GetChallenges(username))
+		return
+	}
+	opts, err := parseGameOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c, err := createChallenge(username, r.URL.Query().Get("to"), false, opts, r.URL.Query().Get("colour"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	challengesMutex.Lock()
+	view := *c
+	challengesMutex.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(view)
+}
+
+// HandleChallengeAnswer accepts or declines a challenge over REST, e.g.
+// POST /api/challenges/accept?code=abcd2345&username=bob. An accepted game
+// starts once both sides are connected to /ws/game with join=<code>.
+func HandleChallengeAnswer(accept bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "POST required", http.StatusMethodNotAllowed)
+			return
+		}
+		username := r.URL.Query().Get("username")
+		c := findChallenge(r.URL.Query().Get("code"))
+		if username == "" || c == nil {
+			http.Error(w, "Unknown challenge", http.StatusNotFound)
+			return
+		}
+		var err error
+		if accept {
+			err = c.accept(username)
+		} else {
+			err = c.decline(username)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,85 @@
+package matchmaking
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// leaverCooldowns is how long a player must wait before queueing again,
+// by the number of games they abandoned in the last day. It escalates with
+// every game left and the last step applies from then on.
+var leaverCooldowns = []time.Duration{0, 0, 5 * time.Minute, 15 * time.Minute, time.Hour, 4 * time.Hour}
+
+const (
+	// Players who abandoned this many games in the last week are only
+	// matched with each other (or bots)
+	lowPriorityLeaves = 3
+)
+
+// recordLeave notes that a player abandoned a game by disconnecting and not
+// coming back.
+func recordLeave(username, gameID string) {
+	rankMutex.Lock()
+	defer rankMutex.Unlock()
+	_, err := db.Exec(`INSERT INTO abandonments (username, game_id) VALUES (?, ?)`, username, gameID)
+	if err != nil {
+		log.Printf("Error recording abandonment for %s: %v", username, err)
+	}
+}
+
+// LeaverStatus is what a player's abandoned games cost them right now.
+type LeaverStatus struct {
+	Username    string `json:"username"`
+	DayLeaves   int    `json:"day_leaves"`  // games abandoned in the last 24 hours
+	WeekLeaves  int    `json:"week_leaves"` // games abandoned in the last 7 days
+	Cooldown    int    `json:"cooldown"`    // seconds until they may queue again
+	LowPriority bool   `json:"low_priority"`
+}
+
+// GetLeaverStatus works out a player's cooldown and pool from the games
+// they abandoned recently.
+func GetLeaverStatus(username string) LeaverStatus {
+	rankMutex.Lock()
+	defer rankMutex.Unlock()
+
+	status := LeaverStatus{Username: username}
+	var lastLeft int64
+	err := db.QueryRow(`
+		SELECT
+			COUNT(CASE WHEN created_at >= datetime('now', '-1 day') THEN 1 END),
+			COUNT(*),
+			IFNULL(CAST(strftime('%s', MAX(created_at)) AS INTEGER), 0)
+		FROM abandonments
+		WHERE username = ? AND created_at >= datetime('now', '-7 days')
+	`, username).Scan(&status.DayLeaves, &status.WeekLeaves, &lastLeft)
+	if err != nil {
+		log.Printf("Error fetching abandonments for %s: %v", username, err)
+		return status
+	}
+
+	step := status.DayLeaves
+	if step >= len(leaverCooldowns) {
+		step = len(leaverCooldowns) - 1
+	}
+	if until := time.Unix(lastLeft, 0).Add(leaverCooldowns[step]); time.Now().Before(until) {
+		status.Cooldown = int(time.Until(until).Round(time.Second).Seconds())
+	}
+	status.LowPriority = status.WeekLeaves >= lowPriorityLeaves
+	return status
+}
+
+// message explains the status to the player.
+func (st LeaverStatus) message() string {
+	switch {
+	case st.Cooldown > 0:
+		return fmt.Sprintf("You left %d game(s) in the last day and can queue again in %s.",
+			st.DayLeaves, (time.Duration(st.Cooldown) * time.Second).String())
+	case st.LowPriority:
+		return fmt.Sprintf("You left %d games this week, so you are only matched with other players who leave games.",
+			st.WeekLeaves)
+	default:
+		return fmt.Sprintf("You left %d game(s) recently. Leaving more games will stop you from queueing for a while.",
+			st.WeekLeaves)
+	}
+}
@@ -0,0 +1,298 @@
+package matchmaking
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// LivePlayer is one seat of a game in progress.
+type LivePlayer struct {
+	Username    string  `json:"username"`
+	Rating      float64 `json:"rating"`
+	Provisional bool    `json:"provisional"`
+	Bot         bool    `json:"bot"`
+}
+
+// LiveGame describes a game in progress.
+type LiveGame struct {
+	ID          string       `json:"game_id"`
+	Players     []LivePlayer `json:"players"`
+	Rating      float64      `json:"rating"` // average over the seats
+	Rated       bool         `json:"rated"`
+	Board       string       `json:"board"`
+	TimeControl string       `json:"time_control"`
+	Fog         bool         `json:"fog"`
+	Moves       int          `json:"moves"`
+	Spectators  int          `json:"spectators"`
+	Watchable   bool         `json:"watchable"`
+	Featured    bool         `json:"featured"`
+	Started     time.Time    `json:"started_at"`
+
+	session *GameSession
+}
+
+var (
+	// Connections to /ws/tv following the featured game, and those that
+	// still wait for their first TV_GAME, each wrapped in a Player for its
+	// write lock
+	tvViewers = make(map[*Player]bool)
+	tvJoining = make(map[*Player]bool)
+	featured  *GameSession // the game on TV, nil when there is none
+	tvMutex   sync.Mutex   // To protect tvViewers, tvJoining and featured
+
+	// Signalled whenever the TV may have to switch games, handled by one
+	// goroutine
+	tvWake = make(chan struct{}, 1)
+	tvOnce sync.Once
+)
+
+// live describes the session, reporting false once the game is over.
+func (s *GameSession) live() (LiveGame, bool) {
+	g := s.Game
+	g.Mutex.Lock()
+	info := LiveGame{
+		ID:          g.ID,
+		Rated:       g.Rated(),
+		Board:       fmt.Sprintf("%dx%d", g.Rows, g.Cols),
+		TimeControl: g.TimeControl(),
+		Fog:         g.Fog,
+		Moves:       len(g.Moves),
+		Started:     g.StartTime,
+		session:     s,
+	}
+	over := g.Over()
+	g.Mutex.Unlock()
+	if over || s.isDone() {
+		return info, false
+	}
+
+	total := 0.0
+	for _, p := range s.Players {
+		player := LivePlayer{Username: p.Username, Bot: p.Bot}
+		if p.Bot {
+			player.Rating = botLevelRating(p.searchDepth(len(s.Players)))
+		} else {
+			r := GetRating(p.Username)
+			player.Rating = math.Round(r.Rating)
+			player.Provisional = r.Provisional()
+		}
+		total += player.Rating
+		info.Players = append(info.Players, player)
+	}
+	info.Rating = math.Round(total / float64(len(s.Players)))
+	info.Spectators = len(s.watchers()) + len(s.tvAudience())
+	info.Watchable = s.watchable()
+	tvMutex.Lock()
+	info.Featured = featured == s
+	tvMutex.Unlock()
+	return info, true
+}
+
+// watchable reports whether spectators can follow the game right now.
+func (s *GameSession) watchable() bool {
+	return !s.isDone() && !s.Game.Fog && s.spectatingAllowed()
+}
+
+// GetLiveGames lists the games in progress, highest rated first. A limit
+// of 0 lists them all.
+func GetLiveGames(limit int) []LiveGame {
+	mutex.Lock()
+	sessions := make([]*GameSession, 0, len(games))
+	for _, s := range games {
+		sessions = append(sessions, s)
+	}
+	mutex.Unlock()
+
+	list := []LiveGame{}
+	for _, s := range sessions {
+		if info, ok := s.live(); ok {
+			list = append(list, info)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Rating != list[j].Rating {
+			return list[i].Rating > list[j].Rating
+		}
+		return list[i].ID < list[j].ID
+	})
+	if limit > 0 && len(list) > limit {
+		list = list[:limit]
+	}
+	return list
+}
+
+// HandleLiveGames lists the games in progress, e.g. /api/games/live?limit=20
+func HandleLiveGames(w http.ResponseWriter, r *http.Request) {
+	limit := 50
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(GetLiveGames(limit))
+}
+
+// tvAudience returns the TV viewers if the game is the one on TV.
+func (s *GameSession) tvAudience() []*Player {
+	tvMutex.Lock()
+	defer tvMutex.Unlock()
+	if featured != s {
+		return nil
+	}
+	viewers := make([]*Player, 0, len(tvViewers))
+	for v := range tvViewers {
+		viewers = append(viewers, v)
+	}
+	return viewers
+}
+
+// audience returns everybody following the game without playing in it.
+func (s *GameSession) audience() []*Player {
+	return append(s.watchers(), s.tvAudience()...)
+}
+
+// wakeTV asks the TV to check whether it has to switch games.
+func wakeTV() {
+	select {
+	case tvWake <- struct{}{}:
+	default:
+	}
+}
+
+// runTV keeps the TV on the best game there is.
+func runTV() {
+	for range tvWake {
+		for !tvStep() {
+		}
+	}
+}
+
+// tvStep keeps the TV on the featured game while it can be watched, and
+// otherwise switches to the highest rated game that can. Viewers get a
+// TV_GAME with the game so far whenever it switches, and newcomers get one
+// either way. It reports false if the game it picked ended before it could
+// be shown.
+func tvStep() bool {
+	tvMutex.Lock()
+	current := featured
+	tvMutex.Unlock()
+
+	next := current
+	if current == nil || !current.watchable() {
+		next = nil
+		for _, info := range GetLiveGames(0) {
+			if info.Watchable {
+				next = info.session
+				break
+			}
+		}
+	}
+
+	if next == nil {
+		tvMutex.Lock()
+		featured = nil
+		notify := make([]*Player, 0, len(tvJoining))
+		for v := range tvJoining {
+			notify = append(notify, v)
+			tvViewers[v] = true
+			delete(tvJoining, v)
+		}
+		if current != nil {
+			for v := range tvViewers {
+				notify = append(notify, v)
+			}
+		}
+		tvMutex.Unlock()
+		for _, v := range notify {
+			v.Send(map[string]interface{}{"type": "TV_IDLE", "message": "No live games to show right now."})
+		}
+		return true
+	}
+
+	// Take the snapshot and switch under the game lock, but send it after
+	// unlocking so a slow viewer holds up neither the game nor the TV. The
+	// viewers' live events are held back until the snapshot is written.
+	g := next.Game
+	g.Mutex.Lock()
+	if g.Over() {
+		g.Mutex.Unlock()
+		return false
+	}
+	msg := next.tvMessage()
+
+	tvMutex.Lock()
+	var notify []*Player
+	if next != current {
+		log.Printf("TV switched to game %s.", g.ID)
+		for v := range tvViewers {
+			notify = append(notify, v)
+		}
+	}
+	for v := range tvJoining {
+		notify = append(notify, v)
+		tvViewers[v] = true
+		delete(tvJoining, v)
+	}
+	for _, v := range notify {
+		v.hold()
+	}
+	featured = next
+	tvMutex.Unlock()
+	g.Mutex.Unlock()
+
+	for _, v := range notify {
+		v.release(msg)
+	}
+	return true
+}
+
+// tvMessage builds the TV_GAME message for the game. Call it with the game
+// locked.
+func (s *GameSession) tvMessage() map[string]interface{} {
+	msg := s.watchMessage(len(s.watchers()))
+	msg["type"] = "TV_GAME"
+	return msg
+}
+
+// HandleTV follows the featured game: the highest rated live game anyone
+// can watch. Viewers get a TV_GAME with the board, the moves so far and
+// the clocks, then every event spectators get. When the game ends the TV
+// switches to the next one with another TV_GAME, or sends TV_IDLE while
+// there is nothing to show.
+func HandleTV(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Println("Upgrade error:", err)
+		return
+	}
+	viewer := &Player{Username: r.URL.Query().Get("username"), Conn: conn}
+
+	tvOnce.Do(func() { go runTV() })
+	tvMutex.Lock()
+	tvJoining[viewer] = true
+	tvMutex.Unlock()
+	wakeTV()
+
+	// The TV is read-only; reading just notices the socket closing
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			break
+		}
+	}
+	tvMutex.Lock()
+	delete(tvViewers, viewer)
+	delete(tvJoining, viewer)
+	tvMutex.Unlock()
+	conn.Close()
+}
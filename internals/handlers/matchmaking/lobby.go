@@ -0,0 +1,161 @@
+package matchmaking
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// Lobby events a watcher can fall behind by before it is dropped
+	lobbyBacklog = 64
+
+	// How long writing one lobby event to a watcher may take
+	lobbyWriteTimeout = 10 * time.Second
+)
+
+var (
+	// Connections to /ws/lobby
+	lobbyWatchers = make(map[*lobbyWatcher]bool)
+	lobbyMutex    sync.Mutex // To protect lobbyWatchers
+)
+
+// lobbyWatcher is a connection to /ws/lobby with the events still to be
+// written to it, in the order they happened.
+type lobbyWatcher struct {
+	conn   *websocket.Conn
+	events chan map[string]interface{}
+}
+
+// announceSeek tells everybody watching the lobby that a seek was posted,
+// accepted or cancelled. Must be called with challengesMutex held, which
+// keeps the events in order. It never waits on a watcher: one too far
+// behind is disconnected instead.
+func announceSeek(typ string, c *Challenge) {
+	msg := map[string]interface{}{
+		"type": typ,
+		"seek": *c,
+	}
+	lobbyMutex.Lock()
+	defer lobbyMutex.Unlock()
+	for w := range lobbyWatchers {
+		select {
+		case w.events <- msg:
+		default:
+			log.Println("Dropping a lobby watcher that fell behind.")
+			w.remove()
+			w.conn.Close()
+		}
+	}
+}
+
+// remove stops sending events to the watcher. Must be called with
+// lobbyMutex held.
+func (w *lobbyWatcher) remove() {
+	if lobbyWatchers[w] {
+		delete(lobbyWatchers, w)
+		close(w.events)
+	}
+}
+
+// write sends the watcher its events until it is removed or a write fails.
+func (w *lobbyWatcher) write() {
+	for msg := range w.events {
+		w.conn.SetWriteDeadline(time.Now().Add(lobbyWriteTimeout))
+		if err := w.conn.WriteJSON(msg); err != nil {
+			w.conn.Close()
+			return
+		}
+	}
+}
+
+// GetSeeks lists the seeks nobody has picked yet, oldest first.
+func GetSeeks() []Challenge {
+	challengesMutex.Lock()
+	defer challengesMutex.Unlock()
+	seeks := []Challenge{}
+	for _, c := range challenges {
+		if c.Seek && !c.Accepted {
+			seeks = append(seeks, *c)
+		}
+	}
+	sort.Slice(seeks, func(i, j int) bool { return seeks[i].Expires.Before(seeks[j].Expires) })
+	return seeks
+}
+
+// HandleLobby lists the open seeks (GET) or posts one (POST) with the same
+// settings as /ws/game, e.g. POST /api/lobby?username=alice&time=3%2B2.
+// The seeker then connects to /ws/game with join=<code>, and whoever picks
+// the seek does the same.
+func HandleLobby(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(GetSeeks())
+		return
+	}
+	username := r.URL.Query().Get("username")
+	if username == "" {
+		http.Error(w, "Username required", http.StatusBadRequest)
+		return
+	}
+	opts, err := parseGameOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c, err := createChallenge(username, "", true, opts, r.URL.Query().Get("colour"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	challengesMutex.Lock()
+	view := *c
+	challengesMutex.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(view)
+}
+
+// HandleLobbySocket streams the lobby: the open seeks when connecting, then
+// SEEK_CREATED, SEEK_ACCEPTED and SEEK_CANCELLED as they happen.
+func HandleLobbySocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Println("Upgrade error:", err)
+		return
+	}
+	watcher := &lobbyWatcher{conn: conn, events: make(chan map[string]interface{}, lobbyBacklog)}
+
+	// Register before listing so no seek falls in between; a seek posted
+	// meanwhile may show up twice, which clients can tell by its code.
+	// Events are queued until the list is written.
+	lobbyMutex.Lock()
+	lobbyWatchers[watcher] = true
+	lobbyMutex.Unlock()
+	conn.SetWriteDeadline(time.Now().Add(lobbyWriteTimeout))
+	err = conn.WriteJSON(map[string]interface{}{
+		"type":  "LOBBY",
+		"seeks": GetSeeks(),
+	})
+	if err == nil {
+		go watcher.write()
+	} else {
+		conn.Close()
+	}
+
+	// The lobby is read-only; reading just notices the socket closing
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			break
+		}
+	}
+	lobbyMutex.Lock()
+	watcher.remove()
+	lobbyMutex.Unlock()
+	conn.Close()
+}
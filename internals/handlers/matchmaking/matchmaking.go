@@ -1,11 +1,14 @@
 package matchmaking
 
 import (
+	"Connect-4/internals/config"
 	"Connect-4/internals/handlers/game"
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
 
@@ -17,40 +20,521 @@ type Player struct {
 	// UserID   string // A unique identifier for the user (e.g., token, unique username)
 	Username string
 	Conn     *websocket.Conn
-	ID       int // 1 or 2
+	ID       int  // seat number, 1..N
+	Bot      bool // bots have no connection and never reconnect
+	Depth    int  // how far ahead a bot searches, 0 for the usual depth for its game
+
+	writeMu    sync.Mutex   // gorilla/websocket allows only one concurrent writer
+	session    *GameSession // the game the player is seated in, guarded by writeMu
+	pool       *pool        // the pool the player waits in until seated, guarded by writeMu
+	challenge  *Challenge   // the challenge the player waits on until seated, guarded by writeMu
+	tournament *Tournament  // the tournament the player's games come from, guarded by writeMu
+	left       bool         // gave up waiting for a game before being seated, guarded by writeMu
+
+	// A spectator's live events wait here until their snapshot is written,
+	// see hold; guarded by writeMu
+	holding bool
+	held    []interface{}
+}
+
+// Send writes a JSON message to the player. It is a no-op for bots and for
+// players who are currently disconnected.
+func (p *Player) Send(msg interface{}) {
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+	if p.holding {
+		p.held = append(p.held, msg)
+		return
+	}
+	if p.Conn != nil {
+		p.Conn.WriteJSON(msg)
+	}
+}
+
+// hold queues the messages sent to the player from now on, until release.
+func (p *Player) hold() {
+	p.writeMu.Lock()
+	p.holding = true
+	p.writeMu.Unlock()
+}
+
+// release writes first, then every message held back since hold, and lets
+// later messages through.
+func (p *Player) release(first interface{}) {
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+	if p.Conn != nil {
+		p.Conn.WriteJSON(first)
+		for _, msg := range p.held {
+			p.Conn.WriteJSON(msg)
+		}
+	}
+	p.holding, p.held = false, nil
+}
+
+// setConn swaps the player's connection, e.g. to nil on disconnect or to a
+// fresh socket on reconnect.
+func (p *Player) setConn(conn *websocket.Conn) {
+	p.writeMu.Lock()
+	p.Conn = conn
+	p.writeMu.Unlock()
+}
+
+// connected reports whether the player has a live connection. Bots are
+// always there.
+func (p *Player) connected() bool {
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+	return p.Bot || p.Conn != nil
+}
+
+// setSession seats the player in a game.
+func (p *Player) setSession(s *GameSession) {
+	p.writeMu.Lock()
+	p.session = s
+	p.writeMu.Unlock()
+}
+
+// leaveQueue takes a player who is not seated yet out of matchmaking. If
+// the player was seated in the meantime it returns that session instead.
+// A player matched but not seated yet is flagged, and startSession calls
+// their game off.
+func (p *Player) leaveQueue() *GameSession {
+	p.writeMu.Lock()
+	if p.session != nil {
+		s := p.session
+		p.writeMu.Unlock()
+		return s
+	}
+	p.left = true
+	q, c, t := p.pool, p.challenge, p.tournament
+	p.writeMu.Unlock()
+	if q != nil {
+		q.remove(p)
+	}
+	if c != nil {
+		c.leave(p)
+	}
+	if t != nil {
+		t.detach(p)
+	}
+	leaveDuo(p)
+	return nil
+}
+
+// pendingChallenge returns the challenge the player is connected to.
+func (p *Player) pendingChallenge() *Challenge {
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+	return p.challenge
+}
+
+// hasLeft reports whether the player left the queue before being seated.
+func (p *Player) hasLeft() bool {
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+	return p.left
+}
+
+// currentSession returns the game the player is seated in.
+func (p *Player) currentSession() *GameSession {
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+	return p.session
+}
+
+// dropConn clears the player's connection if it is still conn, reporting
+// whether it did. A player who already reconnected keeps the new socket.
+func (p *Player) dropConn(conn *websocket.Conn) bool {
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+	if p.Conn != conn {
+		return false
+	}
+	p.Conn = nil
+	return true
+}
+
+// GameSession ties a running game to the players seated in it and to the
+// channel every seat's moves are funnelled through.
+type GameSession struct {
+	Game    *game.Game
+	Players []*Player // Players[i] sits in seat i+1
+
+	moves chan Move
+	done  chan struct{}
+	once  sync.Once
+
+	// Sides (by piece) that agreed to the open draw offer, nil when there
+	// is none. Guarded by the game's mutex.
+	drawOffer map[int]bool
+
+	// The open takeback request, nil when there is none, and the takebacks
+	// granted to each seat so far. Guarded by the game's mutex.
+	takeback  *takebackRequest
+	takebacks map[int]int
+
+	// Set when the players' connections are already being read, because
+	// the game is a rematch on the same connections
+	readersRunning bool
+	series         *seriesScore // score of the games so far between these players, nil for a first game
+
+	// Players who want a rematch once the game is over, and whether the
+	// rematch is off (declined, someone left or already started). Guarded
+	// by the game's mutex.
+	rematch       map[*Player]bool
+	rematchClosed bool
+
+	// Spectators following the game and the seats that turned spectating
+	// off, see spectators.go
+	spectatorMu  sync.Mutex
+	spectators   map[*Player]bool
+	noSpectators map[int]bool
+
+	// The tournament pairing the game is played for, nil outside
+	// tournaments, and the seat that got the game aborted, set before
+	// the game is cancelled
+	pairing   *Pairing
+	abortedBy int
+
+	arena *Arena // the arena the game is played in, nil outside arenas
+}
+
+// isDone reports whether the game loop has stopped.
+func (s *GameSession) isDone() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// seatOf returns the seat the player sits in, or 0.
+func (s *GameSession) seatOf(p *Player) int {
+	for i, other := range s.Players {
+		if other == p {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// broadcast sends the same message to every seat and every spectator.
+func (s *GameSession) broadcast(msg interface{}) {
+	for _, p := range s.Players {
+		p.Send(msg)
+	}
+	for _, w := range s.audience() {
+		w.Send(msg)
+	}
+}
+
+// finish stops every goroutine attached to the session. It is safe to call
+// more than once.
+func (s *GameSession) finish() {
+	s.once.Do(func() { close(s.done) })
+}
+
+// startMessage builds the GAME_START message for one seat.
+func (s *GameSession) startMessage(p *Player) map[string]interface{} {
+	g := s.Game
+	return map[string]interface{}{
+		"type":            "GAME_START",
+		"game_id":         g.ID,
+		"state":           g.State,
+		"board":           g.ViewFor(p.ID),
+		"fog":             g.Fog,
+		"player_number":   p.ID,
+		"team":            g.Team(p.ID),
+		"player1_name":    g.PlayerName(1),
+		"player2_name":    g.PlayerName(2),
+		"players":         g.Players,
+		"teams":           g.Teams,
+		"colours":         g.Colours(),
+		"casual":          g.Casual,
+		"rated":           g.Rated(),
+		"time_control":    g.TimeControl(),
+		"clocks":          g.Clocks(time.Now()),
+		"layout":          g.LayoutName,
+		"obstacles":       g.Obstacles,
+		"starting_player": g.Turn,
+		"series":          s.series,
+		"spectators":      len(s.watchers()),
+	}
+}
+
+// moveMessages builds the MOVE message for every seat, in seat order. In
+// fog-of-war games each seat gets its own view of the board, and is only
+// told where the disc landed if it can see that cell. Call it with the game
+// locked.
+func (s *GameSession) moveMessages(base map[string]interface{}, row, col int) []map[string]interface{} {
+	g := s.Game
+	msgs := make([]map[string]interface{}, len(s.Players))
+	for i, p := range s.Players {
+		msg := copyMessage(base)
+		if g.Fog {
+			msg["board"] = g.ViewFor(p.ID)
+			if !g.Visible(p.ID, row, col) {
+				delete(msg, "col")
+				delete(msg, "row")
+			}
+		}
+		msgs[i] = msg
+	}
+	return msgs
+}
+
+// sendEach sends msgs[i] to the player in seat i+1. Spectators get the
+// first seat's message; they are never let into fog-of-war games, where
+// the messages differ.
+func (s *GameSession) sendEach(msgs []map[string]interface{}) {
+	for i, p := range s.Players {
+		p.Send(msgs[i])
+	}
+	for _, w := range s.audience() {
+		w.Send(msgs[0])
+	}
+}
+
+// copyMessage returns a shallow copy of a message so it can be tailored to
+// one recipient.
+func copyMessage(msg map[string]interface{}) map[string]interface{} {
+	c := make(map[string]interface{}, len(msg))
+	for k, v := range msg {
+		c[k] = v
+	}
+	return c
 }
 
 // You'll also need a struct to store in the cache
 type CachedGame struct {
-	Game        *game.Game
-	Player1     *Player
-	Player2     *Player
+	Session     *GameSession
+	Player      *Player // the player who dropped out
 	Timestamp   time.Time
 	CancelTimer context.CancelFunc // To track when the game was cached
 }
 
+// GameOptions are the settings a player picks when asking for a game.
+type GameOptions struct {
+	Seats     int    // number of players, 2 to 4
+	Teams     bool   // 2v2: seats 1 and 3 play against seats 2 and 4
+	Obstacles string // "" for none, "random" or the name of a layout
+	Fog       bool   // fog of war: players only see around their own discs
+	Rows      int    // board size, 0 for the usual size for the number of seats
+	Cols      int
+	Position  string // custom starting position, see game.NewGameFromPosition; "" for the empty board
+
+	TimeControl string // e.g. "5+3", see game.ParseTimeControl; "" for untimed
+	BestOf      int    // length of a best-of-N series, 0 for a single game
+	Casual      bool   // casual games never change the rankings and allow takebacks and hints
+
+	lowPriority bool   // the pool for players who keep abandoning games
+	arena       *Arena // the arena the pool pairs players for, nil outside arenas
+}
+
+// parseGameOptions reads the game settings from the /ws/game query string.
+func parseGameOptions(r *http.Request) (GameOptions, error) {
+	q := r.URL.Query()
+	opts := GameOptions{Seats: minSeats}
+	switch q.Get("mode") {
+	case "", "ffa":
+	case "teams":
+		opts.Teams = true
+		opts.Seats = teamSeats
+	default:
+		return opts, fmt.Errorf("unknown mode %q", q.Get("mode"))
+	}
+	if v := q.Get("players"); v != "" && !opts.Teams {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < minSeats || n > maxSeats {
+			return opts, fmt.Errorf("players must be between %d and %d", minSeats, maxSeats)
+		}
+		opts.Seats = n
+	}
+	if name := q.Get("obstacles"); name != "" {
+		if _, ok := game.Layouts[name]; !ok && name != game.RandomLayout {
+			return opts, fmt.Errorf("unknown obstacle layout %q", name)
+		}
+		opts.Obstacles = name
+	}
+	if v := q.Get("board"); v != "" {
+		var rows, cols int
+		if _, err := fmt.Sscanf(v, "%dx%d", &rows, &cols); err != nil {
+			return opts, fmt.Errorf("board must be given as <rows>x<columns>, e.g. 7x6")
+		}
+		if rows < game.MinBoardSize || rows > game.MaxBoardSize || cols < game.MinBoardSize || cols > game.MaxBoardSize {
+			return opts, fmt.Errorf("rows and columns must be between %d and %d", game.MinBoardSize, game.MaxBoardSize)
+		}
+		// Asking for the usual board is the same queue as not asking
+		if r, c := game.BoardSize(opts.Seats); rows != r || cols != c {
+			opts.Rows, opts.Cols = rows, cols
+		}
+	}
+	// Named layouts are drawn for the two-player board
+	if opts.Obstacles != "" && opts.Obstacles != game.RandomLayout {
+		rows, cols := opts.Rows, opts.Cols
+		if rows == 0 {
+			rows, cols = game.BoardSize(opts.Seats)
+		}
+		if r, c := game.BoardSize(minSeats); rows != r || cols != c {
+			return opts, fmt.Errorf("obstacle layouts are only available on the %dx%d board", r, c)
+		}
+	}
+	if v := q.Get("fog"); v != "" {
+		fog, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, fmt.Errorf("fog must be true or false")
+		}
+		opts.Fog = fog
+	}
+	if position := q.Get("position"); position != "" {
+		switch {
+		case opts.Teams:
+			return opts, errors.New("custom positions are only available in free-for-all games")
+		case opts.Obstacles != "":
+			return opts, errors.New("put obstacles in the position with # instead")
+		case opts.Rows > 0:
+			return opts, errors.New("custom positions are played on the usual board")
+		}
+		if _, err := game.NewGameFromPosition("", make([]string, opts.Seats), position); err != nil {
+			return opts, fmt.Errorf("invalid position: %v", err)
+		}
+		opts.Position = position
+	}
+
+	// The server picks the time control unless the player asks for another
+	opts.TimeControl = defaultTimeControl
+	if v := q.Get("time"); v == "none" {
+		opts.TimeControl = ""
+	} else if v != "" {
+		tc, err := game.ParseTimeControl(v)
+		if err != nil {
+			return opts, err
+		}
+		opts.TimeControl = tc.String()
+	}
+	if v := q.Get("rated"); v != "" {
+		rated, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, fmt.Errorf("rated must be true or false")
+		}
+		opts.Casual = !rated
+	}
+	// Games from a custom position are for practice and handicaps, never rated
+	if opts.Position != "" {
+		opts.Casual = true
+	}
+	if v := q.Get("best_of"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxBestOf {
+			return opts, fmt.Errorf("best_of must be between 1 and %d", maxBestOf)
+		}
+		if n > 1 && (opts.Seats != 2 || opts.Teams) {
+			return opts, fmt.Errorf("series are only available in two-player games")
+		}
+		if n > 1 && opts.Position != "" {
+			return opts, errors.New("series cannot start from a custom position")
+		}
+		if n > 1 {
+			opts.BestOf = n
+		}
+	}
+	return opts, nil
+}
+
+// setClock gives a game the clock described by opts.
+func setClock(g *game.Game, opts GameOptions) {
+	if opts.TimeControl == "" {
+		return
+	}
+	tc, err := game.ParseTimeControl(opts.TimeControl)
+	if err != nil {
+		log.Printf("Ignoring time control for game %s: %v", g.ID, err)
+		return
+	}
+	g.Clock = game.NewClock(tc, len(g.Players))
+}
+
+// newGame creates the game described by opts for the given players, listed
+// in seat order.
+func newGame(id string, names []string, opts GameOptions) *game.Game {
+	var g *game.Game
+	if opts.Teams {
+		g = game.NewTeamGame(id, names)
+	} else {
+		g = game.NewMultiplayerGame(id, names)
+	}
+	if opts.Position != "" {
+		// parseGameOptions has already checked the position
+		if from, err := game.NewGameFromPosition(id, names, opts.Position); err != nil {
+			log.Printf("Could not set up the position of game %s: %v", id, err)
+		} else {
+			g = from
+		}
+	}
+	if opts.Rows > 0 {
+		if err := g.Resize(opts.Rows, opts.Cols); err != nil {
+			log.Printf("Could not resize the board of game %s: %v", id, err)
+		}
+	}
+	g.Fog = opts.Fog
+	g.Casual = opts.Casual
+	setClock(g, opts)
+
+	switch opts.Obstacles {
+	case "":
+	case game.RandomLayout:
+		g.PlaceObstacles(opts.Obstacles, game.RandomObstacles(g.Rows, g.Cols, randomObstaclePairs))
+	default:
+		if err := g.PlaceObstacles(opts.Obstacles, game.Layouts[opts.Obstacles]); err != nil {
+			log.Printf("Could not use layout %s for game %s: %v", opts.Obstacles, id, err)
+		}
+	}
+	return g
+}
+
 // Move represents the message structure for a player's move
 type Move struct {
 	Type   string `json:"type"`
 	Col    int    `json:"col"`
 	Player int    `json:"player"`
+	Auto   bool   `json:"-"` // played by the server when the player's time for the move ran out
+
+	// For moves worked out ahead of time (by a bot), the move number they
+	// were meant for, so a move made stale by a takeback is dropped
+	ply int
 }
 
 var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool { return true },
 }
 
+const (
+	minSeats = 2
+	maxSeats = 4
+
+	// Mirrored pairs of neutral stones on a board with random obstacles
+	randomObstaclePairs = 3
+)
+
 var (
-	// Make queue a channel to simplify waiting logic
-	playerQueue            = make(chan *Player, 1)
-	games                  = make(map[string]*game.Game)
+	// One pool (and one matchmaking goroutine) per set of game options
+	pools                  = make(map[GameOptions]*pool)
+	queuesMutex            sync.Mutex // To protect pools
+	games                  = make(map[string]*GameSession)
 	mutex                  sync.Mutex // To protect the games map
 	botTimeout             = 10 * time.Second
+	reconnectionTimeout    = 30 * time.Second
+	defaultTimeControl     = ""          // untimed unless the config says otherwise
+	moveTimeout            time.Duration // 0 means a move may take as long as it likes
+	moveWarning            time.Duration // how long before the move deadline players are warned
+	ratedTakebacks         int           // takebacks each player gets in a rated game, 0 for none
+	abortWindow            time.Duration // time a player has for their first move before the game is aborted, 0 for no limit
+	ratingPeriod           time.Duration // time away that grows a player's rating deviation by a step, 0 for no decay
 	disconnectedGamesCache *lru.Cache
 )
 
-// A single, dedicated goroutine to handle all matchmaking.
-// This should be started once when your server starts.
 func init() {
 	// Initialize the cache. Let's say we want to store up to 100 disconnected games.
 	// If the 101st game is added, the least recently used one is automatically removed.
@@ -59,28 +543,44 @@ func init() {
 	if err != nil {
 		log.Fatalf("Could not initialize LRU cache: %v", err)
 	}
-	go Matchmaker()
 }
 
-func Matchmaker() {
-	log.Println("Matchmaker started...")
-	for {
-		// Wait for a player to enter the queue
-		p1 := <-playerQueue
-		log.Printf("Player %s is in the queue, waiting for an opponent or timeout.", p1.Username)
-
-		select {
-		case p2 := <-playerQueue:
-			// An opponent was found!
-			log.Printf("Match found: %s vs %s", p1.Username, p2.Username)
-			go startGame(p1, p2)
-		case <-time.After(botTimeout):
-			// Timeout occurred, start a bot game for p1
-			log.Printf("No opponent found for %s, starting a bot game.", p1.Username)
-			botPlayer := &Player{Username: "Bot", ID: 2} // Conn is nil for bot
-			go startGame(p1, botPlayer)
+// Configure applies the game settings from the config file. Settings left
+// at zero keep their defaults.
+func Configure(cfg *config.Config) {
+	if cfg.Game.MatchmakingTimeoutSeconds > 0 {
+		botTimeout = time.Duration(cfg.Game.MatchmakingTimeoutSeconds) * time.Second
+	}
+	if cfg.Game.ReconnectTimeoutSeconds > 0 {
+		reconnectionTimeout = time.Duration(cfg.Game.ReconnectTimeoutSeconds) * time.Second
+	}
+	moveTimeout = time.Duration(cfg.Game.MoveTimeoutSeconds) * time.Second
+	moveWarning = time.Duration(cfg.Game.MoveWarningSeconds) * time.Second
+	ratedTakebacks = cfg.Game.RatedTakebacks
+	abortWindow = time.Duration(cfg.Game.AbortWindowSeconds) * time.Second
+	if cfg.Game.TournamentForfeitSeconds > 0 {
+		tournamentForfeit = time.Duration(cfg.Game.TournamentForfeitSeconds) * time.Second
+	}
+	if cfg.Game.ChallengeExpirySeconds > 0 {
+		challengeTimeout = time.Duration(cfg.Game.ChallengeExpirySeconds) * time.Second
+	}
+	ratingPeriod = time.Duration(cfg.Game.RatingPeriodDays) * 24 * time.Hour
+	if cfg.Game.DefaultTimeControl != "" {
+		tc, err := game.ParseTimeControl(cfg.Game.DefaultTimeControl)
+		if err != nil {
+			log.Fatalf("Invalid default_time_control: %v", err)
 		}
+		defaultTimeControl = tc.String()
+	}
+}
+
+// newBot creates the n-th bot of a game. The first one is simply "Bot".
+func newBot(n int) *Player {
+	name := "Bot"
+	if n > 1 {
+		name = fmt.Sprintf("Bot%d", n)
 	}
+	return &Player{Username: name, Bot: true} // Conn is nil for bot
 }
 
 func HandleGame(w http.ResponseWriter, r *http.Request) {
@@ -89,6 +589,47 @@ func HandleGame(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Username required", http.StatusBadRequest)
 		return
 	}
+	opts, err := parseGameOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// A challenge or private room skips the queue for a game between friends
+	challenge, err := challengeFromRequest(username, opts, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Tournament players get their games on this connection as the rounds are paired
+	tourney, err := tournamentFromRequest(username, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Arena players are paired again after every game until the arena ends
+	arena, err := arenaFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if challenge != nil && (tourney != nil || arena != nil) {
+		http.Error(w, "challenges cannot be played in tournaments or arenas", http.StatusBadRequest)
+		return
+	}
+
+	// A custom starting position skips the queue for a casual game against
+	// the bot, unless it is played out in a challenge between friends
+	var sandbox *game.Game
+	if opts.Position != "" && challenge == nil {
+		sandbox, err = newSandboxGame(username, opts, r.URL.Query().Get("seat"))
+		if err != nil {
+			http.Error(w, "Invalid position: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
 
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
@@ -99,116 +640,500 @@ func HandleGame(w http.ResponseWriter, r *http.Request) {
 	// --- RECONNECTION LOGIC ---
 	if val, ok := disconnectedGamesCache.Get(username); ok {
 		cachedGame := val.(*CachedGame)
-		log.Printf("Player %s is reconnecting to game %s", username, cachedGame.Game.ID)
+		s := cachedGame.Session
+		p := cachedGame.Player
+		log.Printf("Player %s is reconnecting to game %s", username, s.Game.ID)
 
 		if cachedGame.CancelTimer != nil {
 			cachedGame.CancelTimer()
 		}
-
-		// Find which player is reconnecting and update their connection
-		var p1, p2 *Player
-		if cachedGame.Player1.Username == username {
-			cachedGame.Player1.Conn = conn
-			p1 = cachedGame.Player1
-			p2 = cachedGame.Player2
-		} else {
-			cachedGame.Player2.Conn = conn
-			p1 = cachedGame.Player1
-			p2 = cachedGame.Player2
+		disconnectedGamesCache.Remove(username)
+		p.setConn(conn)
+
+		s.Game.Mutex.Lock()
+		now := time.Now()
+		s.resumeIfBack(now)
+		start := s.startMessage(p)
+		reconnected := make([]map[string]interface{}, len(s.Players))
+		for i, other := range s.Players {
+			reconnected[i] = map[string]interface{}{
+				"type":          "OPPONENT_RECONNECTED",
+				"message":       fmt.Sprintf("%s has reconnected!", username),
+				"game_id":       s.Game.ID,
+				"state":         s.Game.State,
+				"clocks":        s.Game.Clocks(now),
+				"board":         s.Game.ViewFor(other.ID),
+				"next_turn":     s.Game.Turn,
+				"player_number": other.ID,
+				"player1_name":  s.Game.PlayerName(1),
+				"player2_name":  s.Game.PlayerName(2),
+				"players":       s.Game.Players,
+			}
 		}
+		s.Game.Mutex.Unlock()
 
-		// Remove from cache and move back to active games
-		disconnectedGamesCache.Remove(p1.Username)
-		disconnectedGamesCache.Remove(p2.Username)
-		mutex.Lock()
-		games[cachedGame.Game.ID] = cachedGame.Game
-		mutex.Unlock()
-
-		// Notify players and resume the game
 		// Send GAME_START to the reconnecting player
-		if p1.Conn != nil {
-			p1.Conn.WriteJSON(map[string]interface{}{
-				"type":            "GAME_START",
-				"game_id":         cachedGame.Game.ID,
-				"board":           cachedGame.Game.Board,
-				"player_number":   p1.ID,
-				"player1_name":    cachedGame.Game.Player1,
-				"player2_name":    cachedGame.Game.Player2,
-				"starting_player": cachedGame.Game.Turn,
-			})
+		p.Send(start)
+
+		// Notify the other players if they are still connected
+		for i, other := range s.Players {
+			if other != p {
+				other.Send(reconnected[i])
+			}
 		}
+		go readMoves(p, conn)
+		return
+	}
 
-		// Notify opponent if they are still connected
-		if p2.Conn != nil {
-			p2.Conn.WriteJSON(map[string]interface{}{
-				"type":          "OPPONENT_RECONNECTED",
-				"message":       "Your opponent has reconnected!",
-				"game_id":       cachedGame.Game.ID,
-				"board":         cachedGame.Game.Board,
-				"next_turn":     cachedGame.Game.Turn,
-				"player_number": p2.ID,
-				"player1_name":  cachedGame.Game.Player1,
-				"player2_name":  cachedGame.Game.Player2,
-			})
+	// --- LEAVER CHECK ---
+	if status := GetLeaverStatus(username); status.WeekLeaves > 0 {
+		conn.WriteJSON(map[string]interface{}{
+			"type":    "LEAVER_STATUS",
+			"message": status.message(),
+			"status":  status,
+		})
+		if status.Cooldown > 0 && sandbox == nil && tourney == nil {
+			log.Printf("Player %s is on a leaver cooldown for %ds.", username, status.Cooldown)
+			conn.WriteMessage(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "leaver cooldown"))
+			conn.Close()
+			return
 		}
-		go handleGamePlay(cachedGame.Game, p1, p2)
+		// Premade duos must be in the same queue, so 2v2 has no separate pool
+		opts.lowPriority = status.LowPriority && !opts.Teams
+	}
+
+	// --- SANDBOX LOGIC ---
+	if sandbox != nil {
+		startSandboxGame(sandbox, &Player{Username: username, Conn: conn})
 		return
 	}
 
 	// --- NEW PLAYER LOGIC ---
 
 	player := &Player{Username: username, Conn: conn}
-	log.Printf("Player %s connected and is being added to the queue.", username)
+	go readMoves(player, conn)
+	if tourney != nil {
+		tourney.attach(player)
+		return
+	}
+	if arena != nil {
+		arena.enter(player)
+		return
+	}
+	if challenge != nil {
+		if challenge.Code == "" {
+			challenge.open()
+		}
+		challenge.attach(player)
+		return
+	}
+	if opts.Teams {
+		log.Printf("Player %s connected and is joining the team queue.", username)
+		joinTeamQueue(player, r.URL.Query().Get("partner"), opts)
+		return
+	}
+	log.Printf("Player %s connected and is being added to the %d-player pool.", username, opts.Seats)
+
+	// Simply add the player to the pool. Its matchmaking goroutine will handle the rest.
+	poolFor(opts).join(player)
+}
+
+// startGame seats the players in the order given and starts the game loop.
+func startGame(players []*Player, opts GameOptions) {
+	names := make([]string, len(players))
+	for i, p := range players {
+		p.ID = i + 1
+		names[i] = p.Username
+	}
 
-	// Simply add the player to the queue. The Matchmaker goroutine will handle the rest.
-	playerQueue <- player
+	// Queued players already have their connections read by readMoves
+	id := newGameID(players[0].Username)
+	s := &GameSession{Game: newGame(id, names, opts), Players: players, readersRunning: true}
+	if opts.BestOf > 0 {
+		s.series = startSeries(names, opts.BestOf)
+	}
+	if opts.arena != nil {
+		s.arena = opts.arena
+		s.rematchClosed = true // arena players are paired again by the arena
+	}
+	startSession(s)
 }
 
-// The rest of the file (startGame, handleGamePlay) can remain largely the same.
-// Just ensure that the access to the shared 'games' map is protected correctly.
+// newGameID makes an ID for a new game started by the given player.
+func newGameID(username string) string {
+	return time.Now().Format("150405") + username
+}
 
-func startGame(p1, p2 *Player) {
-	isBotGame := p2.Conn == nil
+// runGame registers a game whose players are already seated, sends
+// everyone GAME_START and starts the game loop.
+func runGame(g *game.Game, players []*Player) {
+	startSession(&GameSession{Game: g, Players: players})
+}
 
-	id := time.Now().Format("150405") + p1.Username
-	g := game.NewGame(id, p1.Username, p2.Username)
+// startSession registers a session whose players are already seated, sends
+// everyone GAME_START and starts the game loop.
+func startSession(s *GameSession) {
+	g := s.Game
+	s.moves = make(chan Move)
+	s.done = make(chan struct{})
+	s.takebacks = make(map[int]int)
+	if s.series != nil {
+		g.SeriesID = s.series.ID
+	}
 
 	mutex.Lock()
-	games[id] = g
+	games[g.ID] = s
 	mutex.Unlock()
 
-	p1.ID, p2.ID = 1, 2
+	if err := g.Start(time.Now()); err != nil {
+		log.Printf("Game %s: %v", g.ID, err)
+	}
 
-	// Message for Player 1
-	p1.Conn.WriteJSON(map[string]interface{}{
-		"type":            "GAME_START",
-		"game_id":         g.ID,
-		"board":           g.Board,
-		"player_number":   p1.ID,
-		"player1_name":    g.Player1,
-		"player2_name":    g.Player2,
-		"starting_player": g.Turn,
+	// Bots have no connection, so Send skips them
+	for _, p := range s.Players {
+		p.setSession(s)
+		p.Send(s.startMessage(p))
+	}
+
+	go handleGamePlay(s)
+	wakeTV()
+
+	for _, p := range s.Players {
+		if p.hasLeft() {
+			go s.cancel(fmt.Sprintf("%s left the queue before the game started.", p.Username))
+			break
+		}
+	}
+}
+
+// readMoves forwards everything a player sends on conn to the game they are
+// seated in until the connection drops. Once a game is over the player can
+// still ask for a rematch, which carries on over the same connection.
+// While the player is still queued it only listens for LEAVE_QUEUE and
+// answers to a challenge, and a dropped connection takes the player out of
+// the queue.
+func readMoves(p *Player, conn *websocket.Conn) {
+	for {
+		var move Move
+		err := conn.ReadJSON(&move)
+		s := p.currentSession()
+		if s == nil && (err != nil || move.Type == "LEAVE_QUEUE") {
+			s = p.leaveQueue()
+			if s == nil {
+				if err != nil {
+					log.Printf("Player %s disconnected while queued: %v", p.Username, err)
+					return
+				}
+				log.Printf("Player %s left the queue.", p.Username)
+				p.Send(map[string]interface{}{
+					"type":    "QUEUE_LEFT",
+					"message": "You left the queue.",
+				})
+				conn.Close()
+				return
+			}
+			if err == nil {
+				continue // too late, the game has started
+			}
+		}
+		if s == nil {
+			if c := p.pendingChallenge(); c != nil {
+				c.answer(p, move.Type)
+			}
+			continue // nothing else to do until the game starts
+		}
+		if err != nil {
+			if s.isDone() {
+				p.dropConn(conn)
+				s.leaveRematch(p)
+				p.leaveQueue() // an arena may have queued them for their next game meanwhile
+				return
+			}
+			log.Printf("Player %d (%s) disconnected: %v", p.ID, p.Username, err)
+			handleDisconnection(s, p, conn)
+			return
+		}
+		if s.isDone() {
+			s.answerRematch(p, move.Type)
+			continue
+		}
+		// The seat comes from the connection, never from the client
+		move.Player = s.seatOf(p)
+		select {
+		case s.moves <- move:
+		case <-s.done:
+		}
+	}
+}
+
+// botDepth is how far ahead the bot searches. Bigger boards with more
+// seats branch much faster, so the bot looks less far ahead there.
+func botDepth(seats int) int {
+	if seats > 2 {
+		return 4
+	}
+	return 6
+}
+
+// searchDepth is how far ahead a bot player searches in a game with the
+// given number of seats.
+func (p *Player) searchDepth(seats int) int {
+	if p.Depth > 0 {
+		return p.Depth
+	}
+	return botDepth(seats)
+}
+
+// playBot makes moves for a bot seat whenever it is its turn.
+func (s *GameSession) playBot(p *Player) {
+	g := s.Game
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		g.Mutex.Lock()
+		if g.State != game.StateActive || g.Turn != p.ID || g.CheckDraw() {
+			g.Mutex.Unlock()
+			continue
+		}
+		// The bot searches the real board, fog of war or not
+		snapshot := g.Clone()
+		g.Mutex.Unlock()
+
+		time.Sleep(1 * time.Second)
+		botMove := Move{
+			Type:   "MOVE",
+			Col:    game.FindBestMove(snapshot, p.searchDepth(len(snapshot.Players))),
+			Player: p.ID,
+			ply:    len(snapshot.Moves) + 1,
+		}
+		select {
+		case s.moves <- botMove:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// resultMessage describes how a finished game ended.
+func resultMessage(g *game.Game) string {
+	winner := g.Winner()
+	if winner == "draw" {
+		return "It's a draw!"
+	}
+	return winner + " wins!"
+}
+
+// finishGame records a finished game, tells every seat and tears the
+// session down. The game must already be over.
+func finishGame(s *GameSession, msg map[string]interface{}) {
+	g := s.Game
+	g.Mutex.Lock()
+	id := SaveGame(g)
+	recordGamesPlayed(s.humanNames())
+	if g.Rated() && g.State == game.StateFinished && id != 0 {
+		msg["ratings"] = ratingsMessage(g, rateGame(s, id))
+	}
+	msg["type"] = "GAME_OVER"
+	msg["state"] = g.State
+	msg["result"] = g.Result
+	msg["reason"] = g.Reason
+	msg["placements"] = g.Placements()
+	msg["clocks"] = g.Clocks(time.Now())
+	msg["board"] = g.ViewFor(0) // the whole board, fog or not
+	inSeries := s.series != nil && s.series.BestOf > 0
+	if inSeries {
+		s.rematchClosed = true // the series goes on by itself
+	}
+	g.Mutex.Unlock()
+
+	s.broadcast(msg)
+	s.dismissSpectators("The game is over.")
+
+	// Close the done channel to stop all goroutines
+	s.finish()
+	if inSeries {
+		go s.continueSeries()
+	}
+
+	// Clean up game from map
+	mutex.Lock()
+	delete(games, g.ID)
+	mutex.Unlock()
+	log.Printf("Game %s ended and cleaned up.", g.ID)
+	wakeTV() // the TV moves on if it showed this game
+	if s.pairing != nil {
+		go s.pairing.t.record(s.pairing, s, id)
+	}
+	if s.arena != nil {
+		go s.arena.record(s)
+	}
+
+	// Keep connections open - let clients close when they're ready
+	// This prevents unexpected disconnection that might trigger page reloads
+}
+
+// eliminate drops a seat out of a running game, e.g. on a forfeit or when
+// its clock runs out, and tells everyone. If only one side is left the
+// game is over and recorded with the given reason.
+func (s *GameSession) eliminate(seat int, reason game.Reason, message string) {
+	g := s.Game
+	g.Mutex.Lock()
+	if g.Over() || !g.Active(seat) {
+		g.Mutex.Unlock()
+		return
+	}
+	now := time.Now()
+	if g.Clock != nil && g.Clock.Running == seat {
+		g.Clock.Punch(now)
+	}
+	g.Eliminate(seat, reason)
+	over := g.Over()
+	if !over {
+		g.StartTurn(now)
+		s.resumeIfBack(now)
+	}
+	nextTurn := g.Turn
+	clocks := g.Clocks(now)
+	state := g.State
+	g.Mutex.Unlock()
+
+	if over {
+		message = fmt.Sprintf("%s %s", message, resultMessage(g))
+		log.Printf("Game %s ended (%s). %s", g.ID, reason, message)
+		finishGame(s, map[string]interface{}{"message": message})
+		return
+	}
+
+	// More than one side is left, so the game goes on without them
+	s.broadcast(map[string]interface{}{
+		"type":      "PLAYER_FORFEITED",
+		"message":   message,
+		"player":    seat,
+		"reason":    reason,
+		"state":     state,
+		"next_turn": nextTurn,
+		"clocks":    clocks,
 	})
+}
 
-	// Message for Player 2 (only if not a bot)
-	if !isBotGame {
-		p2.Conn.WriteJSON(map[string]interface{}{
-			"type":            "GAME_START",
-			"game_id":         g.ID,
-			"board":           g.Board,
-			"player_number":   p2.ID,
-			"player1_name":    g.Player1,
-			"player2_name":    g.Player2,
-			"starting_player": g.Turn,
-		})
+// resumeIfBack resumes a game paused for a disconnect once every seat still
+// playing is connected again. Call it with the game locked.
+func (s *GameSession) resumeIfBack(now time.Time) {
+	g := s.Game
+	if g.State != game.StatePaused {
+		return
+	}
+	for _, seat := range g.ActiveSeats() {
+		if !s.Players[seat-1].connected() {
+			return
+		}
+	}
+	if err := g.Resume(now); err != nil {
+		log.Printf("Game %s: %v", g.ID, err)
+	}
+}
+
+// watchTurn enforces the limits on the seat to move. A seat whose clock
+// runs out loses on time: the server's clock is the only one that counts.
+// A human who sits on a move past the per-move timeout is warned as the
+// deadline approaches and then forfeits, or in casual games has a random
+// move played for them.
+func (s *GameSession) watchTurn() {
+	g := s.Game
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	var warned, expired time.Time // the turns already warned about and timed out
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+		g.Mutex.Lock()
+		if g.Over() {
+			g.Mutex.Unlock()
+			return
+		}
+		if g.State != game.StateActive {
+			g.Mutex.Unlock()
+			continue // the clocks are stopped while the game is paused
+		}
+		flagged := 0
+		if g.Clock != nil {
+			flagged = g.Clock.Flagged(now)
+		}
+		seat := g.Turn
+		turn := g.TurnStarted
+		// A first move is covered by the abort window instead
+		unmoved := abortWindow > 0 && !s.Players[seat-1].Bot && !g.HasMoved(seat)
+		var left time.Duration
+		if moveTimeout > 0 {
+			left = moveTimeout - now.Sub(turn)
+		}
+		randomCol := -1
+		if moveTimeout > 0 && left <= 0 && g.Casual {
+			randomCol = game.RandomMove(g)
+		}
+		g.Mutex.Unlock()
+
+		if flagged != 0 {
+			s.eliminate(flagged, game.ReasonTimeout, fmt.Sprintf("%s ran out of time.", g.PlayerName(flagged)))
+			continue
+		}
+		p := s.Players[seat-1]
+		if unmoved {
+			if now.Sub(turn) >= abortWindow {
+				s.abort(seat, abortMessage(p.Username, false))
+			}
+			continue
+		}
+		if moveTimeout == 0 || p.Bot {
+			continue
+		}
+
+		switch {
+		case left <= 0 && !expired.Equal(turn):
+			expired = turn
+			if !g.Casual {
+				log.Printf("Game %s: %s took longer than %v to move.", g.ID, p.Username, moveTimeout)
+				s.eliminate(seat, game.ReasonTimeout, fmt.Sprintf("%s took too long to move.", p.Username))
+				continue
+			}
+			log.Printf("Game %s: %s took longer than %v, playing column %d for them.", g.ID, p.Username, moveTimeout, randomCol)
+			select {
+			case s.moves <- Move{Type: "MOVE", Col: randomCol, Player: seat, Auto: true}:
+			case <-s.done:
+				return
+			}
+		case left <= moveWarning && !warned.Equal(turn):
+			warned = turn
+			p.Send(map[string]interface{}{
+				"type":         "MOVE_TIMEOUT_WARNING",
+				"message":      fmt.Sprintf("Move within %d seconds or %s.", int(left.Round(time.Second).Seconds()), timeoutAction(g)),
+				"seconds_left": int(left.Round(time.Second).Seconds()),
+			})
+		}
 	}
+}
 
-	go handleGamePlay(g, p1, p2)
+// timeoutAction says what happens when the per-move timeout runs out.
+func timeoutAction(g *game.Game) string {
+	if g.Casual {
+		return "a random move will be played for you"
+	}
+	return "you forfeit the game"
 }
 
-func handleGamePlay(g *game.Game, p1, p2 *Player) {
-	moves := make(chan Move)
-	done := make(chan struct{})
+func handleGamePlay(s *GameSession) {
+	g := s.Game
+
 	// Timer goroutine (runs parallel to existing goroutines)
 	go func() {
 		ticker := time.NewTicker(1 * time.Second)
@@ -216,117 +1141,86 @@ func handleGamePlay(g *game.Game, p1, p2 *Player) {
 
 		for {
 			select {
-			case <-done:
+			case <-s.done:
 				return
 			case <-ticker.C:
 				g.Mutex.Lock()
-				if g.Over {
+				if g.Over() {
 					g.Mutex.Unlock()
 					return
 				}
 				elapsed := time.Since(g.StartTime)
+				clocks := g.Clocks(time.Now())
 				g.Mutex.Unlock()
 
-				// Send timer update to both players
-				timerMsg := map[string]interface{}{
+				// Send timer update to every seat
+				s.broadcast(map[string]interface{}{
 					"type":    "TIMER_UPDATE",
 					"elapsed": int(elapsed.Seconds()),
-				}
-
-				if p1.Conn != nil {
-					p1.Conn.WriteJSON(timerMsg)
-				}
-				if p2.Conn != nil {
-					p2.Conn.WriteJSON(timerMsg)
-				}
+					"clocks":  clocks,
+				})
 			}
 		}
 	}()
 
-	// Goroutine to read messages from player 1
-	go func() {
-		for {
-			select {
-			case <-done:
-				return
-			default:
-				var move Move
-				if err := p1.Conn.ReadJSON(&move); err != nil {
-					select {
-					case <-done:
-						return
-					default:
-						log.Println("done channel not closed")
-						log.Printf("Player 1 (%s) disconnected: %v", p1.Username, err)
-						handleDisconnection(g, p1, p2)
-						return
-					}
-				}
-				moves <- move
-			}
-		}
-	}()
+	if g.Clock != nil || moveTimeout > 0 || abortWindow > 0 {
+		go s.watchTurn()
+	}
 
-	// Conditionally start the goroutine for player 2
-	if p2.Conn != nil {
-		go func() {
-			for {
-				select {
-				case <-done:
-					return
-				default:
-					var move Move
-					if err := p2.Conn.ReadJSON(&move); err != nil {
-						select {
-						case <-done:
-							return
-						default:
-							log.Println("done channel not closed")
-							log.Printf("Player 2 (%s) disconnected: %v", p2.Username, err)
-							handleDisconnection(g, p2, p1)
-							return
-						}
-					}
-					moves <- move
-				}
-			}
-		}()
-	} else {
-		// Goroutine for bot player moves
-		go func() {
-			for {
-				select {
-				case <-done:
-					return
-				default:
-					if g.Turn == 2 {
-						time.Sleep(1 * time.Second)
-
-						if g.CheckDraw() {
-							return
-						}
-
-						bestCol := game.FindBestMove(g, 6)
-
-						botMove := Move{
-							Type:   "MOVE",
-							Col:    bestCol,
-							Player: 2,
-						}
-						moves <- botMove
-					}
-					time.Sleep(100 * time.Millisecond)
-				}
-			}
-		}()
+	// One goroutine per seat: read from humans, think for bots
+	for _, p := range s.Players {
+		if p.Bot {
+			go s.playBot(p)
+		} else if !s.readersRunning {
+			go readMoves(p, p.Conn)
+		}
 	}
 
 	// Main Game Loop
-	for move := range moves {
+	for {
+		var move Move
+		select {
+		case move = <-s.moves:
+		case <-s.done:
+			return
+		}
+
+		switch move.Type {
+		case "RESIGN":
+			s.resign(move.Player)
+			continue
+		case "OFFER_DRAW":
+			s.offerDraw(move.Player)
+			continue
+		case "ACCEPT_DRAW", "DECLINE_DRAW":
+			s.answerDraw(move.Player, move.Type == "ACCEPT_DRAW")
+			continue
+		case "TAKEBACK_REQUEST":
+			s.requestTakeback(move.Player)
+			continue
+		case "TAKEBACK_ACCEPT", "TAKEBACK_DECLINE":
+			s.answerTakeback(move.Player, move.Type == "TAKEBACK_ACCEPT")
+			continue
+		case "HINT":
+			s.hint(move.Player)
+			continue
+		case "SPECTATORS_OFF", "SPECTATORS_ON":
+			s.allowSpectators(move.Player, move.Type == "SPECTATORS_ON")
+			continue
+		}
+
 		g.Mutex.Lock()
 
-		if move.Player != g.Turn {
+		if g.State != game.StateActive || move.Player != g.Turn || (move.ply != 0 && move.ply != len(g.Moves)+1) {
+			g.Mutex.Unlock()
+			continue
+		}
+
+		now := time.Now()
+		if g.Clock != nil && g.Clock.Flagged(now) == move.Player {
+			// The move came in after the flag fell
 			g.Mutex.Unlock()
+			s.eliminate(move.Player, game.ReasonTimeout, fmt.Sprintf("%s ran out of time.", g.PlayerName(move.Player)))
 			continue
 		}
 
@@ -340,134 +1234,104 @@ func handleGamePlay(g *game.Game, p1, p2 *Player) {
 		for i := 0; i < len(g.Board); i++ {
 			log.Printf("Row %d: %v", i, g.Board[i])
 		}
-		response := map[string]interface{}{
+
+		if g.Clock != nil {
+			g.Clock.Punch(now)
+		}
+		// A move turns down any open draw offer or takeback request
+		s.drawOffer = nil
+		s.takeback = nil
+		if g.CheckWin(row, col, g.Piece(move.Player)) {
+			log.Printf("*** WIN DETECTED for Player %d ***", move.Player)
+			g.RecordWin(move.Player)
+		} else if g.CheckDraw() {
+			g.RecordDraw(game.ReasonBoardFull)
+		}
+		g.StartTurn(now)
+		place := g.Places[move.Player-1]
+		over := g.Over()
+		nextTurn := g.Turn
+		msgs := s.moveMessages(map[string]interface{}{
 			"type":      "MOVE",
 			"col":       col,
 			"row":       row,
 			"player":    move.Player,
-			"next_turn": g.Turn,
-		}
-		if p1.Conn != nil {
-			p1.Conn.WriteJSON(response)
-		}
-		if p2.Conn != nil {
-			p2.Conn.WriteJSON(response)
-		}
-		if g.CheckWin(row, col, move.Player) {
-			log.Printf("*** WIN DETECTED for Player %d ***", move.Player)
-			winnerName := g.Player1
-			if move.Player == 2 {
-				winnerName = g.Player2
-			}
-			AddWin(winnerName)
-			SaveGame(g.Player1, g.Player2, winnerName, g.Moves)
-			msg := map[string]interface{}{
-				"type":    "GAME_OVER",
-				"message": winnerName + " wins!",
-			}
-			log.Printf("Game %s ended. Winner: %s", g.ID, winnerName)
-
-			g.Over = true
-			g.Mutex.Unlock()
-
-			// Send GAME_OVER message
-			if p1.Conn != nil {
-				p1.Conn.WriteJSON(msg)
-			}
-			if p2.Conn != nil {
-				p2.Conn.WriteJSON(msg)
-			}
-
-			// Close the done channel to stop all goroutines
-			close(done)
-
-			// Clean up game from map
-			mutex.Lock()
-			delete(games, g.ID)
-			mutex.Unlock()
-			log.Printf("Game %s ended and cleaned up.", g.ID)
-
-			// Keep connections open - let clients close when they're ready
-			// This prevents unexpected disconnection that might trigger page reloads
-			return
-
-		} else if g.CheckDraw() {
-			SaveGame(g.Player1, g.Player2, "draw", g.Moves)
-			msg := map[string]interface{}{
-				"type":    "GAME_OVER",
-				"message": "It's a draw!",
-			}
-			log.Println("Game ended in a draw.")
-
-			g.Over = true
-			g.Mutex.Unlock()
-
-			// Send GAME_OVER message
-			if p1.Conn != nil {
-				p1.Conn.WriteJSON(msg)
-			}
-			if p2.Conn != nil {
-				p2.Conn.WriteJSON(msg)
-			}
-
-			// Close the done channel to stop all goroutines
-			close(done)
+			"team":      g.Team(move.Player),
+			"next_turn": nextTurn,
+			"clocks":    g.Clocks(now),
+			"auto":      move.Auto,
+			"state":     g.State,
+		}, row, col)
+		g.Mutex.Unlock()
 
-			// Clean up game from map
-			mutex.Lock()
-			delete(games, g.ID)
-			mutex.Unlock()
-			log.Printf("Game %s ended and cleaned up.", g.ID)
+		s.sendEach(msgs)
 
-			// Keep connections open - let clients close when they're ready
-			// This prevents unexpected disconnection that might trigger page reloads
+		if over {
+			message := resultMessage(g)
+			log.Printf("Game %s ended. %s", g.ID, message)
+			finishGame(s, map[string]interface{}{"message": message})
 			return
 		}
-
-		g.Mutex.Unlock()
+		if place != 0 {
+			// Someone connected four but the others play on for the remaining places
+			s.broadcast(map[string]interface{}{
+				"type":      "PLAYER_FINISHED",
+				"player":    move.Player,
+				"name":      g.PlayerName(move.Player),
+				"place":     place,
+				"next_turn": nextTurn,
+			})
+		}
 	}
 }
 
-// Add this constant at the top with your other variables
-const reconnectionTimeout = 30 * time.Second
-
 // Modified handleDisconnection function with timer
-func handleDisconnection(g *game.Game, disconnectedPlayer, otherPlayer *Player) {
-	if g.Over {
+func handleDisconnection(s *GameSession, disconnectedPlayer *Player, conn *websocket.Conn) {
+	g := s.Game
+	g.Mutex.Lock()
+	if g.Over() {
+		g.Mutex.Unlock()
 		return // Game already over, no need to handle disconnection
 	}
-	disconnectedPlayer.Conn = nil
-
-	// Remove from active games
-	mutex.Lock()
-	delete(games, g.ID)
-	mutex.Unlock()
+	if !disconnectedPlayer.dropConn(conn) {
+		g.Mutex.Unlock()
+		return // The player has already reconnected on a new socket
+	}
+	now := time.Now()
+	if g.State == game.StateActive {
+		// Nobody moves and the clocks stop until they are back
+		if err := g.Pause(now); err != nil {
+			log.Printf("Game %s: %v", g.ID, err)
+		}
+	}
+	state := g.State
+	clocks := g.Clocks(now)
+	g.Mutex.Unlock()
 
 	// Create a context with cancel to manage the timer
 	ctx, cancel := context.WithCancel(context.Background())
 	// Build cached game
 	cachedGame := &CachedGame{
-		Game:        g,
-		Player1:     disconnectedPlayer,
-		Player2:     otherPlayer,
+		Session:     s,
+		Player:      disconnectedPlayer,
 		Timestamp:   time.Now(),
 		CancelTimer: cancel, // Store the cancel function
 	}
 
-	// Use player usernames or IDs as cache keys
+	// Use the player's username as the cache key
 	disconnectedGamesCache.Add(disconnectedPlayer.Username, cachedGame)
-	disconnectedGamesCache.Add(otherPlayer.Username, cachedGame)
 
-	log.Printf("Game %s moved to cache due to disconnection of %s. Timer started for %v.",
+	log.Printf("Game %s: %s disconnected. Timer started for %v.",
 		g.ID, disconnectedPlayer.Username, reconnectionTimeout)
 
-	// Notify the remaining player
-	if otherPlayer.Conn != nil {
-		otherPlayer.Conn.WriteJSON(map[string]string{
-			"type":    "OPPONENT_DISCONNECTED",
-			"message": "Your opponent has disconnected. Waiting for them to reconnect...",
-		})
-	}
+	// Notify the remaining players
+	s.broadcast(map[string]interface{}{
+		"type":    "OPPONENT_DISCONNECTED",
+		"message": fmt.Sprintf("%s has disconnected. Waiting for them to reconnect...", disconnectedPlayer.Username),
+		"player":  disconnectedPlayer.ID,
+		"state":   state,
+		"clocks":  clocks,
+	})
 
 	// Start a timer goroutine to handle forfeit after 30 seconds
 	go func() {
@@ -477,40 +1341,35 @@ func handleDisconnection(g *game.Game, disconnectedPlayer, otherPlayer *Player)
 		select {
 		case <-timer.C:
 			// Timer expired - check if player reconnected
-			if _, stillInCache := disconnectedGamesCache.Get(disconnectedPlayer.Username); stillInCache {
-				log.Printf("Reconnection timeout for %s. Game %s is forfeited.",
+			if _, stillInCache := disconnectedGamesCache.Get(disconnectedPlayer.Username); !stillInCache {
+				log.Printf("Player %s reconnected within timeout period for game %s.",
 					disconnectedPlayer.Username, g.ID)
+				return
+			}
+			log.Printf("Reconnection timeout for %s. They forfeit game %s.",
+				disconnectedPlayer.Username, g.ID)
 
-				// Remove from cache
-				disconnectedGamesCache.Remove(disconnectedPlayer.Username)
-				disconnectedGamesCache.Remove(otherPlayer.Username)
-
-				// Mark game as over
-				g.Mutex.Lock()
-				g.Over = true
-				g.Mutex.Unlock()
-
-				// Determine winner (the player who stayed connected)
-				winnerName := otherPlayer.Username
-
-				// Save the game result
-				AddWin(winnerName)
-				SaveGame(g.Player1, g.Player2, winnerName, g.Moves)
-
-				// Notify the connected player about the forfeit
-				if otherPlayer.Conn != nil {
-					otherPlayer.Conn.WriteJSON(map[string]interface{}{
-						"type":    "GAME_OVER",
-						"message": fmt.Sprintf("%s forfeited. You win!", disconnectedPlayer.Username),
-						"reason":  "opponent_timeout",
-					})
-				}
+			// Remove from cache
+			disconnectedGamesCache.Remove(disconnectedPlayer.Username)
 
-				log.Printf("Game %s ended due to forfeit. Winner: %s", g.ID, winnerName)
-			} else {
-				log.Printf("Player %s reconnected within timeout period for game %s.",
-					disconnectedPlayer.Username, g.ID)
+			// Leaving before the first move aborts the game instead
+			g.Mutex.Lock()
+			over := g.Over()
+			moved := g.HasMoved(disconnectedPlayer.ID)
+			g.Mutex.Unlock()
+			if over {
+				return
 			}
+			if !moved {
+				s.abort(disconnectedPlayer.ID, abortMessage(disconnectedPlayer.Username, true))
+				return
+			}
+
+			// The player who left drops to the worst open place, and it
+			// counts against them as an abandoned game
+			recordLeave(disconnectedPlayer.Username, g.ID)
+			s.eliminate(disconnectedPlayer.ID, game.ReasonDisconnect,
+				fmt.Sprintf("%s forfeited.", disconnectedPlayer.Username))
 
 		case <-ctx.Done():
 			// Timer was cancelled due to reconnection
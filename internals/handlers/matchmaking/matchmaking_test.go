@@ -0,0 +1,36 @@
+package matchmaking
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseGameOptionsObstacles(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    string // the obstacles option
+		wantErr bool
+	}{
+		{query: "obstacles=pillars", want: "pillars"},
+		{query: "obstacles=random", want: "random"},
+		{query: "obstacles=steps&board=7x6&players=3", want: "steps"},
+		{query: "obstacles=random&players=4", want: "random"},
+		{query: "obstacles=random&board=10x10", want: "random"},
+		{query: "obstacles=volcano", wantErr: true},
+		{query: "obstacles=steps&players=3", wantErr: true},
+		{query: "obstacles=bridge&mode=teams", wantErr: true},
+		{query: "obstacles=funnel&board=8x8", wantErr: true},
+		{query: "obstacles=funnel&position=44", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			opts, err := parseGameOptions(httptest.NewRequest("GET", "/ws/game?"+tt.query, nil))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseGameOptions() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && opts.Obstacles != tt.want {
+				t.Errorf("obstacles = %q, want %q", opts.Obstacles, tt.want)
+			}
+		})
+	}
+}
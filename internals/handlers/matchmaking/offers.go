@@ -0,0 +1,276 @@
+package matchmaking
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"Connect-4/internals/handlers/game"
+)
+
+// resign ends the game for the seat's side. With more than two sides left
+// the others play on for the remaining places.
+func (s *GameSession) resign(seat int) {
+	name := s.Game.PlayerName(seat)
+	log.Printf("Game %s: %s resigned.", s.Game.ID, name)
+	s.eliminate(seat, game.ReasonResignation, fmt.Sprintf("%s resigned.", name))
+}
+
+// offerDraw offers a draw to every other side still playing. Only one offer
+// can be open at a time, and it lapses as soon as somebody drops a disc.
+// Bots answer on their own.
+func (s *GameSession) offerDraw(seat int) {
+	g := s.Game
+	g.Mutex.Lock()
+	if g.Over() || !g.Active(seat) || s.drawOffer != nil {
+		g.Mutex.Unlock()
+		return
+	}
+	s.drawOffer = map[int]bool{g.Piece(seat): true}
+	var bots []int
+	for _, other := range g.ActiveSeats() {
+		if s.Players[other-1].Bot && g.Piece(other) != g.Piece(seat) {
+			bots = append(bots, other)
+		}
+	}
+	snapshot := g.Clone()
+	g.Mutex.Unlock()
+
+	log.Printf("Game %s: %s offered a draw.", g.ID, g.PlayerName(seat))
+	s.broadcast(map[string]interface{}{
+		"type":    "DRAW_OFFERED",
+		"message": fmt.Sprintf("%s offers a draw.", g.PlayerName(seat)),
+		"player":  seat,
+	})
+	for _, bot := range bots {
+		go s.botAnswerDraw(snapshot, bot)
+	}
+}
+
+// botAnswerDraw lets a bot think about a draw offer and answer it like a
+// player would.
+func (s *GameSession) botAnswerDraw(snapshot *game.Game, seat int) {
+	time.Sleep(1 * time.Second)
+	answer := Move{Type: "DECLINE_DRAW", Player: seat}
+	if game.AcceptsDraw(snapshot, seat, s.Players[seat-1].searchDepth(len(snapshot.Players))) {
+		answer.Type = "ACCEPT_DRAW"
+	}
+	select {
+	case s.moves <- answer:
+	case <-s.done:
+	}
+}
+
+// answerDraw records a side's answer to the open draw offer. One decline
+// withdraws the offer; once every side still playing has accepted the game
+// ends in a draw by agreement.
+func (s *GameSession) answerDraw(seat int, accept bool) {
+	g := s.Game
+	g.Mutex.Lock()
+	if g.Over() || !g.Active(seat) || s.drawOffer == nil || s.drawOffer[g.Piece(seat)] {
+		g.Mutex.Unlock()
+		return
+	}
+	name := g.PlayerName(seat)
+	if !accept {
+		s.drawOffer = nil
+		g.Mutex.Unlock()
+		log.Printf("Game %s: %s declined the draw.", g.ID, name)
+		s.broadcast(map[string]interface{}{
+			"type":    "DRAW_DECLINED",
+			"message": fmt.Sprintf("%s declined the draw.", name),
+			"player":  seat,
+		})
+		return
+	}
+
+	s.drawOffer[g.Piece(seat)] = true
+	for _, other := range g.ActiveSeats() {
+		if !s.drawOffer[g.Piece(other)] {
+			// Still waiting for another side
+			g.Mutex.Unlock()
+			s.broadcast(map[string]interface{}{
+				"type":    "DRAW_ACCEPTED",
+				"message": fmt.Sprintf("%s accepts the draw.", name),
+				"player":  seat,
+			})
+			return
+		}
+	}
+	if g.Clock != nil {
+		g.Clock.Punch(time.Now())
+	}
+	g.RecordDraw(game.ReasonAgreement)
+	s.drawOffer = nil
+	g.Mutex.Unlock()
+
+	log.Printf("Game %s ended in a draw by agreement.", g.ID)
+	finishGame(s, map[string]interface{}{"message": "Draw agreed."})
+}
+
+// takebackRequest is a seat asking to take its last move back.
+type takebackRequest struct {
+	seat   int
+	agreed map[int]bool // sides (by piece) that accepted
+}
+
+// requestTakeback asks every other side still playing to let seat take
+// back its last move, along with any replies made to it. Casual games allow
+// any number of takebacks and bots always grant them there; rated games
+// allow ratedTakebacks per player, to be granted by the opponents.
+func (s *GameSession) requestTakeback(seat int) {
+	g := s.Game
+	p := s.Players[seat-1]
+	g.Mutex.Lock()
+	if g.Over() || !g.Active(seat) || s.takeback != nil {
+		g.Mutex.Unlock()
+		return
+	}
+	refusal := ""
+	if !g.Casual && s.takebacks[seat] >= ratedTakebacks {
+		refusal = "No takebacks left in this rated game."
+		if ratedTakebacks == 0 {
+			refusal = "Takebacks are disabled in rated games."
+		}
+	} else if _, err := g.Clone().TakeBack(seat); err != nil {
+		refusal = fmt.Sprintf("Nothing to take back: %v.", err)
+	}
+	if refusal != "" {
+		g.Mutex.Unlock()
+		p.Send(map[string]interface{}{
+			"type":    "TAKEBACK_DECLINED",
+			"message": refusal,
+			"player":  seat,
+		})
+		return
+	}
+
+	s.takeback = &takebackRequest{seat: seat, agreed: map[int]bool{g.Piece(seat): true}}
+	var bots []int
+	for _, other := range g.ActiveSeats() {
+		if s.Players[other-1].Bot && g.Piece(other) != g.Piece(seat) {
+			bots = append(bots, other)
+		}
+	}
+	casual := g.Casual
+	g.Mutex.Unlock()
+
+	log.Printf("Game %s: %s asked for a takeback.", g.ID, p.Username)
+	s.broadcast(map[string]interface{}{
+		"type":    "TAKEBACK_REQUESTED",
+		"message": fmt.Sprintf("%s asks to take back their last move.", p.Username),
+		"player":  seat,
+	})
+	for _, bot := range bots {
+		answer := Move{Type: "TAKEBACK_DECLINE", Player: bot}
+		if casual {
+			answer.Type = "TAKEBACK_ACCEPT"
+		}
+		go func() {
+			select {
+			case s.moves <- answer:
+			case <-s.done:
+			}
+		}()
+	}
+}
+
+// answerTakeback records a side's answer to the open takeback request. One
+// decline withdraws it; once every side still playing has accepted, the
+// moves are taken back and everyone gets the restored board.
+func (s *GameSession) answerTakeback(seat int, accept bool) {
+	g := s.Game
+	g.Mutex.Lock()
+	req := s.takeback
+	if g.Over() || !g.Active(seat) || req == nil || req.agreed[g.Piece(seat)] {
+		g.Mutex.Unlock()
+		return
+	}
+	name := g.PlayerName(seat)
+	if !accept {
+		s.takeback = nil
+		g.Mutex.Unlock()
+		log.Printf("Game %s: %s declined the takeback.", g.ID, name)
+		s.broadcast(map[string]interface{}{
+			"type":    "TAKEBACK_DECLINED",
+			"message": fmt.Sprintf("%s declined the takeback.", name),
+			"player":  req.seat,
+		})
+		return
+	}
+
+	req.agreed[g.Piece(seat)] = true
+	for _, other := range g.ActiveSeats() {
+		if !req.agreed[g.Piece(other)] {
+			g.Mutex.Unlock() // still waiting for another side
+			return
+		}
+	}
+	s.takeback = nil
+	now := time.Now()
+	if g.Clock != nil {
+		g.Clock.Punch(now)
+	}
+	undone, err := g.TakeBack(req.seat)
+	g.StartTurn(now)
+	if err != nil {
+		g.Mutex.Unlock()
+		log.Printf("Game %s: takeback for seat %d failed: %v", g.ID, req.seat, err)
+		return
+	}
+	s.takebacks[req.seat]++
+	s.drawOffer = nil
+	base := map[string]interface{}{
+		"type":      "TAKEBACK",
+		"message":   fmt.Sprintf("%s took back their last move.", g.PlayerName(req.seat)),
+		"player":    req.seat,
+		"undone":    undone,
+		"next_turn": g.Turn,
+		"clocks":    g.Clocks(now),
+	}
+	msgs := make([]map[string]interface{}, len(s.Players))
+	for i, p := range s.Players {
+		msgs[i] = copyMessage(base)
+		msgs[i]["board"] = g.ViewFor(p.ID)
+	}
+	g.Mutex.Unlock()
+
+	log.Printf("Game %s: took back %d move(s) for seat %d.", g.ID, undone, req.seat)
+	s.sendEach(msgs)
+}
+
+// hint suggests a move to the seat to move, worked out by the bot. Hints
+// are only given in casual games, and not under fog of war, where the bot
+// would give away what the player cannot see.
+func (s *GameSession) hint(seat int) {
+	g := s.Game
+	p := s.Players[seat-1]
+	g.Mutex.Lock()
+	refusal := ""
+	switch {
+	case g.State != game.StateActive || g.Turn != seat:
+		refusal = "Hints are only given on your turn."
+	case !g.Casual:
+		refusal = "Hints are not available in rated games."
+	case g.Fog:
+		refusal = "Hints are not available under fog of war."
+	}
+	snapshot := g.Clone()
+	g.Mutex.Unlock()
+
+	if refusal != "" {
+		p.Send(map[string]interface{}{
+			"type":    "HINT_DENIED",
+			"message": refusal,
+		})
+		return
+	}
+	go func() {
+		col := game.FindBestMove(snapshot, botDepth(len(snapshot.Players)))
+		p.Send(map[string]interface{}{
+			"type":    "HINT",
+			"message": fmt.Sprintf("Try column %d.", col+1),
+			"col":     col,
+		})
+	}()
+}
@@ -0,0 +1,89 @@
+package matchmaking
+
+import (
+	"testing"
+	"time"
+
+	"Connect-4/internals/handlers/game"
+)
+
+// takebackSession seats two players who are not connected, so nothing is
+// sent, and plays the given columns with the seats taking turns.
+func takebackSession(t *testing.T, casual bool, cols ...int) *GameSession {
+	g := game.NewGame("takeback", "alice", "bob")
+	g.Casual = casual
+	if err := g.Start(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	for _, col := range cols {
+		if _, _, err := g.PlaceDisc(g.Turn, col); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &GameSession{
+		Game:      g,
+		Players:   []*Player{{Username: "alice", ID: 1}, {Username: "bob", ID: 2}},
+		takebacks: make(map[int]int),
+		done:      make(chan struct{}),
+	}
+}
+
+func TestRequestTakebackLimits(t *testing.T) {
+	defer func(n int) { ratedTakebacks = n }(ratedTakebacks)
+	tests := []struct {
+		name     string
+		casual   bool
+		allowed  int // ratedTakebacks
+		used     int // takebacks alice already had
+		cols     []int
+		wantOpen bool
+	}{
+		{name: "casual games have no limit", casual: true, used: 5, cols: []int{3, 3}, wantOpen: true},
+		{name: "disabled in rated games", allowed: 0, cols: []int{3, 3}},
+		{name: "rated takeback left", allowed: 1, cols: []int{3, 3}, wantOpen: true},
+		{name: "rated takebacks used up", allowed: 1, used: 1, cols: []int{3, 3}},
+		{name: "nothing to take back", casual: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ratedTakebacks = tt.allowed
+			s := takebackSession(t, tt.casual, tt.cols...)
+			s.takebacks[1] = tt.used
+			s.requestTakeback(1)
+			if open := s.takeback != nil; open != tt.wantOpen {
+				t.Errorf("request open = %v, want %v", open, tt.wantOpen)
+			}
+		})
+	}
+}
+
+func TestAnswerTakeback(t *testing.T) {
+	tests := []struct {
+		name      string
+		accept    bool
+		wantMoves int
+		wantUsed  int
+	}{
+		{name: "accepted", accept: true, wantMoves: 2, wantUsed: 1},
+		{name: "declined", accept: false, wantMoves: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := takebackSession(t, true, 3, 4, 3, 0)
+			s.requestTakeback(1)
+			s.answerTakeback(2, tt.accept)
+			if n := len(s.Game.Moves); n != tt.wantMoves {
+				t.Errorf("%d moves left, want %d", n, tt.wantMoves)
+			}
+			if s.Game.Turn != 1 {
+				t.Errorf("turn = %d, want alice's", s.Game.Turn)
+			}
+			if s.takebacks[1] != tt.wantUsed {
+				t.Errorf("takebacks used = %d, want %d", s.takebacks[1], tt.wantUsed)
+			}
+			if s.takeback != nil {
+				t.Error("the request is still open")
+			}
+		})
+	}
+}
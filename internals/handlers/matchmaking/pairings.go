@@ -0,0 +1,363 @@
+package matchmaking
+
+import (
+	"sort"
+)
+
+// Swiss pairing gives up avoiding rematches after this many steps of its
+// search, which only runs long once nearly everybody has met
+const swissSearchSteps = 100000
+
+// Pairing is one game of a tournament round, or a bye.
+type Pairing struct {
+	Round   int     `json:"round"`
+	Board   int     `json:"board"`
+	Player1 string  `json:"player1"`           // moves first
+	Player2 string  `json:"player2,omitempty"` // "" for a bye
+	Result  string  `json:"result,omitempty"`  // "1-0", "0-1", "draw", "0-0" (both forfeited) or "bye"; "" until decided
+	Forfeit bool    `json:"forfeit,omitempty"` // decided without a game, because a player did not show up
+	GameID  string  `json:"game_id,omitempty"` // the game being played right now
+	Games   []int64 `json:"games"`             // saved games; knockout pairings replay drawn games
+
+	id      int64
+	t       *Tournament
+	session *GameSession
+}
+
+// points is what the pairing earned a player: 1 for a win or a bye, half
+// a point for a draw.
+func (pr *Pairing) points(name string) float64 {
+	switch pr.Result {
+	case "bye", "1-0":
+		if name == pr.Player1 {
+			return 1
+		}
+	case "0-1":
+		if name == pr.Player2 {
+			return 1
+		}
+	case "draw":
+		return 0.5
+	}
+	return 0
+}
+
+// opponent returns who the player faces in the pairing.
+func (pr *Pairing) opponent(name string) string {
+	if name == pr.Player1 {
+		return pr.Player2
+	}
+	return pr.Player1
+}
+
+// TournamentStanding is one player's place in a tournament.
+type TournamentStanding struct {
+	Rank       int     `json:"rank"`
+	Username   string  `json:"username"`
+	Points     float64 `json:"points"`
+	Buchholz   float64 `json:"buchholz"`         // sum of the opponents' points
+	Sonneborn  float64 `json:"sonneborn_berger"` // points of the opponents beaten, half of those drawn
+	Wins       int     `json:"wins"`
+	Played     int     `json:"played"`
+	Rating     float64 `json:"rating"`                  // when the tournament started
+	Eliminated int     `json:"eliminated_in,omitempty"` // knockout round the player went out in
+	Withdrawn  bool    `json:"withdrawn,omitempty"`
+}
+
+// standings ranks the players by points, then by Buchholz, Sonneborn-Berger,
+// wins and rating. In a knockout players who went out later rank higher.
+func (t *Tournament) standings() []TournamentStanding {
+	byName := make(map[string]*TournamentStanding)
+	list := make([]*TournamentStanding, 0, len(t.Players))
+	for _, name := range t.Players {
+		e := t.entrants[name]
+		st := &TournamentStanding{Username: name, Rating: e.rating, Withdrawn: e.withdrawn}
+		byName[name] = st
+		list = append(list, st)
+	}
+
+	for _, pr := range t.pairings {
+		if pr.Result == "" {
+			continue
+		}
+		for _, name := range []string{pr.Player1, pr.Player2} {
+			st, ok := byName[name]
+			if !ok {
+				continue
+			}
+			points := pr.points(name)
+			st.Points += points
+			if pr.Player2 != "" {
+				st.Played++
+			}
+			if points == 1 {
+				st.Wins++
+			}
+			if t.Format == formatKnockout && pr.Player2 != "" && t.advances(pr) != name {
+				st.Eliminated = pr.Round
+			}
+		}
+	}
+	for _, pr := range t.pairings {
+		if pr.Result == "" || pr.Player2 == "" {
+			continue
+		}
+		for _, name := range []string{pr.Player1, pr.Player2} {
+			st, ok := byName[name]
+			if !ok {
+				continue
+			}
+			opp := byName[pr.opponent(name)]
+			if opp == nil {
+				continue
+			}
+			st.Buchholz += opp.Points
+			st.Sonneborn += pr.points(name) * opp.Points
+		}
+	}
+
+	sort.SliceStable(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		if t.Format == formatKnockout && a.Eliminated != b.Eliminated {
+			return a.Eliminated == 0 || (b.Eliminated != 0 && a.Eliminated > b.Eliminated)
+		}
+		switch {
+		case a.Points != b.Points:
+			return a.Points > b.Points
+		case a.Buchholz != b.Buchholz:
+			return a.Buchholz > b.Buchholz
+		case a.Sonneborn != b.Sonneborn:
+			return a.Sonneborn > b.Sonneborn
+		case a.Wins != b.Wins:
+			return a.Wins > b.Wins
+		}
+		return a.Rating > b.Rating
+	})
+	standings := make([]TournamentStanding, len(list))
+	for i, st := range list {
+		st.Rank = i + 1
+		standings[i] = *st
+	}
+	return standings
+}
+
+// advances returns who goes through a decided knockout pairing. A game
+// still drawn after its replays goes to the higher seed.
+func (t *Tournament) advances(pr *Pairing) string {
+	switch pr.Result {
+	case "bye", "1-0":
+		return pr.Player1
+	case "0-1":
+		return pr.Player2
+	case "draw":
+		if t.entrants[pr.Player2].seed < t.entrants[pr.Player1].seed {
+			return pr.Player2
+		}
+		return pr.Player1
+	}
+	return ""
+}
+
+// roundPairings returns the pairings of one round, by board.
+func (t *Tournament) roundPairings(round int) []*Pairing {
+	var list []*Pairing
+	for _, pr := range t.pairings {
+		if pr.Round == round {
+			list = append(list, pr)
+		}
+	}
+	return list
+}
+
+// roundRobinPairings pairs a round of a round robin with the circle
+// method: the top seed stays put while everybody else rotates one place
+// each round, so after all rounds everybody has met everybody once. With
+// an odd number of players whoever would meet the empty spot has a bye.
+// Colours alternate from round to round.
+func (t *Tournament) roundRobinPairings(round int) []*Pairing {
+	ring := append([]string{}, t.Players...)
+	if len(ring)%2 == 1 {
+		ring = append(ring, "")
+	}
+	n := len(ring)
+	rest := ring[1:]
+	k := (round - 1) % len(rest)
+	order := append([]string{ring[0]}, append(append([]string{}, rest[len(rest)-k:]...), rest[:len(rest)-k]...)...)
+
+	var pairs []*Pairing
+	for i := 0; i < n/2; i++ {
+		a, b := order[i], order[n-1-i]
+		if (round+i)%2 == 0 {
+			a, b = b, a
+		}
+		if a == "" {
+			a, b = b, a
+		}
+		pairs = append(pairs, &Pairing{Player1: a, Player2: b})
+	}
+	return pairs
+}
+
+// swissPairings pairs a Swiss round: players are ranked by the standings
+// and paired top down with the closest ranked player they have not met
+// yet. With an odd number of players the lowest ranked player without a
+// bye so far gets one. Players who withdrew are left out.
+func (t *Tournament) swissPairings() []*Pairing {
+	var active []string
+	for _, st := range t.standings() {
+		if !st.Withdrawn {
+			active = append(active, st.Username)
+		}
+	}
+
+	bye := ""
+	if len(active)%2 == 1 {
+		at := len(active) - 1
+		for i := len(active) - 1; i >= 0; i-- {
+			if !t.hadBye(active[i]) {
+				at = i
+				break
+			}
+		}
+		bye = active[at]
+		active = append(active[:at:at], active[at+1:]...)
+	}
+
+	var pairs []*Pairing
+	for _, pair := range pairSwiss(active, t.met) {
+		a, b := pair[0], pair[1]
+		// The player who moved second more often moves first
+		ba, bb := t.colourBalance(a), t.colourBalance(b)
+		if bb < ba || (bb == ba && t.Round%2 == 0) {
+			a, b = b, a
+		}
+		pairs = append(pairs, &Pairing{Player1: a, Player2: b})
+	}
+	if bye != "" {
+		pairs = append(pairs, &Pairing{Player1: bye})
+	}
+	return pairs
+}
+
+// pairSwiss pairs the players, in ranking order, so nobody meets an
+// opponent they already played if that can be done. Otherwise they are
+// simply paired in order.
+func pairSwiss(players []string, met func(a, b string) bool) [][2]string {
+	steps := 0
+	var solve func(rest []string) ([][2]string, bool)
+	solve = func(rest []string) ([][2]string, bool) {
+		if len(rest) == 0 {
+			return nil, true
+		}
+		a := rest[0]
+		for i := 1; i < len(rest); i++ {
+			steps++
+			if steps > swissSearchSteps {
+				return nil, false
+			}
+			if met(a, rest[i]) {
+				continue
+			}
+			others := append(append([]string{}, rest[1:i]...), rest[i+1:]...)
+			if pairs, ok := solve(others); ok {
+				return append([][2]string{{a, rest[i]}}, pairs...), true
+			}
+		}
+		return nil, false
+	}
+	if pairs, ok := solve(players); ok {
+		return pairs
+	}
+	var pairs [][2]string
+	for i := 0; i+1 < len(players); i += 2 {
+		pairs = append(pairs, [2]string{players[i], players[i+1]})
+	}
+	return pairs
+}
+
+// met reports whether two players were paired before.
+func (t *Tournament) met(a, b string) bool {
+	for _, pr := range t.pairings {
+		if (pr.Player1 == a && pr.Player2 == b) || (pr.Player1 == b && pr.Player2 == a) {
+			return true
+		}
+	}
+	return false
+}
+
+// hadBye reports whether the player already had a bye.
+func (t *Tournament) hadBye(name string) bool {
+	for _, pr := range t.pairings {
+		if pr.Player2 == "" && pr.Player1 == name {
+			return true
+		}
+	}
+	return false
+}
+
+// colourBalance is how many more games the player moved first in than
+// second.
+func (t *Tournament) colourBalance(name string) int {
+	balance := 0
+	for _, pr := range t.pairings {
+		switch {
+		case pr.Player2 == "":
+		case pr.Player1 == name:
+			balance++
+		case pr.Player2 == name:
+			balance--
+		}
+	}
+	return balance
+}
+
+// knockoutPairings pairs a knockout round. The first round seeds the
+// bracket so the top seeds can only meet late and get the byes when the
+// field is not a power of two; after that the winners of boards 1 and 2
+// meet, those of boards 3 and 4, and so on. The higher seed moves first.
+func (t *Tournament) knockoutPairings() []*Pairing {
+	var field []string
+	if t.Round == 1 {
+		size := 1
+		for size < len(t.Players) {
+			size *= 2
+		}
+		for _, seed := range bracketOrder(size) {
+			name := ""
+			if seed <= len(t.Players) {
+				name = t.Players[seed-1]
+			}
+			field = append(field, name)
+		}
+	} else {
+		for _, pr := range t.roundPairings(t.Round - 1) {
+			field = append(field, t.advances(pr))
+		}
+	}
+
+	var pairs []*Pairing
+	for i := 0; i+1 < len(field); i += 2 {
+		a, b := field[i], field[i+1]
+		if a == "" || (b != "" && t.entrants[b].seed < t.entrants[a].seed) {
+			a, b = b, a
+		}
+		pairs = append(pairs, &Pairing{Player1: a, Player2: b})
+	}
+	return pairs
+}
+
+// bracketOrder lists the seeds 1..size in bracket order, e.g. 1 8 4 5 2 7
+// 3 6, so that neighbours meet in the first round and the top two seeds
+// can only meet in the final.
+func bracketOrder(size int) []int {
+	order := []int{1}
+	for len(order) < size {
+		n := len(order) * 2
+		next := make([]int, 0, n)
+		for _, seed := range order {
+			next = append(next, seed, n+1-seed)
+		}
+		order = next
+	}
+	return order
+}
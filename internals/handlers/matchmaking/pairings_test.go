@@ -0,0 +1,132 @@
+package matchmaking
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPairSwiss(t *testing.T) {
+	tests := []struct {
+		name    string
+		players []string
+		met     [][2]string
+		want    [][2]string
+	}{
+		{
+			name:    "first round pairs in order",
+			players: []string{"a", "b", "c", "d"},
+			want:    [][2]string{{"a", "b"}, {"c", "d"}},
+		},
+		{
+			name:    "skips an opponent already met",
+			players: []string{"a", "b", "c", "d"},
+			met:     [][2]string{{"a", "b"}},
+			want:    [][2]string{{"a", "c"}, {"b", "d"}},
+		},
+		{
+			name:    "backtracks when the closest pairing leaves a rematch",
+			players: []string{"a", "b", "c", "d"},
+			met:     [][2]string{{"a", "b"}, {"c", "d"}, {"a", "c"}},
+			want:    [][2]string{{"a", "d"}, {"b", "c"}},
+		},
+		{
+			name:    "falls back to ranking order when everybody has met",
+			players: []string{"a", "b", "c", "d"},
+			met:     [][2]string{{"a", "b"}, {"a", "c"}, {"a", "d"}, {"b", "c"}, {"b", "d"}, {"c", "d"}},
+			want:    [][2]string{{"a", "b"}, {"c", "d"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			met := func(a, b string) bool {
+				for _, m := range tt.met {
+					if (m[0] == a && m[1] == b) || (m[0] == b && m[1] == a) {
+						return true
+					}
+				}
+				return false
+			}
+			if got := pairSwiss(tt.players, met); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("pairSwiss() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSwissPairings(t *testing.T) {
+	tests := []struct {
+		name      string
+		round     int
+		withdrawn []string
+		played    []*Pairing
+		wantBye   string
+	}{
+		{
+			name:    "even field has no bye",
+			round:   1,
+			wantBye: "",
+		},
+		{
+			name:      "odd field gives the bye to the lowest ranked player",
+			round:     1,
+			withdrawn: []string{"f"},
+			wantBye:   "e",
+		},
+		{
+			name:      "nobody gets a second bye",
+			round:     2,
+			withdrawn: []string{"f"},
+			played: []*Pairing{
+				{Round: 1, Player1: "a", Player2: "b", Result: "1-0"},
+				{Round: 1, Player1: "c", Player2: "d", Result: "1-0"},
+				{Round: 1, Player1: "e", Result: "bye"},
+			},
+			wantBye: "d",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := &Tournament{
+				Format:   formatSwiss,
+				Round:    tt.round,
+				Players:  []string{"a", "b", "c", "d", "e", "f"},
+				entrants: make(map[string]*entrant),
+				pairings: tt.played,
+			}
+			for i, name := range tr.Players {
+				tr.entrants[name] = &entrant{rating: float64(2000 - 100*i), seed: i + 1}
+			}
+			for _, name := range tt.withdrawn {
+				tr.entrants[name].withdrawn = true
+			}
+
+			bye := ""
+			seen := make(map[string]bool)
+			for _, pr := range tr.swissPairings() {
+				for _, name := range []string{pr.Player1, pr.Player2} {
+					if name == "" {
+						continue
+					}
+					if seen[name] {
+						t.Errorf("%s is paired twice", name)
+					}
+					if tr.entrants[name].withdrawn {
+						t.Errorf("%s withdrew but is paired", name)
+					}
+					seen[name] = true
+				}
+				if pr.Player2 == "" {
+					bye = pr.Player1
+				} else if tr.met(pr.Player1, pr.Player2) {
+					t.Errorf("%s and %s meet again", pr.Player1, pr.Player2)
+				}
+			}
+			if bye != tt.wantBye {
+				t.Errorf("bye = %q, want %q", bye, tt.wantBye)
+			}
+			if want := len(tr.Players) - len(tt.withdrawn); len(seen) != want {
+				t.Errorf("%d players paired, want %d", len(seen), want)
+			}
+		})
+	}
+}
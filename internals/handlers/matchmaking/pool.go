@@ -0,0 +1,434 @@
+package matchmaking
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"Connect-4/internals/handlers/game"
+)
+
+const (
+	// How often a pool looks for games while players are waiting
+	poolTick = 500 * time.Millisecond
+
+	// Players are matched with opponents within ratingWindow points of
+	// their own rating. The window widens by ratingWindowGrowth points for
+	// every second they wait, up to maxRatingWindow.
+	ratingWindow       = 100.0
+	ratingWindowGrowth = 25.0
+	maxRatingWindow    = 600.0
+
+	// How often waiting players get a QUEUE_STATUS update
+	queueStatusInterval = 3 * time.Second
+
+	// Number of recent matches the average wait of a pool is taken over
+	waitSamples = 5
+
+	// Arena players are not paired with their last opponent again until
+	// both have waited this long
+	arenaRematchWait = 10 * time.Second
+)
+
+// botLevels are the search depths bots can play at, weakest first, with
+// the rating each depth plays at. A bot filling in for a missing opponent
+// plays at the level closest to the players it faces.
+var botLevels = []struct {
+	depth  int
+	rating float64
+}{
+	{1, 900},
+	{2, 1100},
+	{3, 1250},
+	{4, 1400},
+	{5, 1500},
+	{6, 1600},
+	{7, 1750},
+	{8, 1900},
+}
+
+// botLevelRating is the rating of a bot searching the given depth.
+func botLevelRating(depth int) float64 {
+	for _, level := range botLevels {
+		if level.depth >= depth {
+			return level.rating
+		}
+	}
+	return botLevels[len(botLevels)-1].rating
+}
+
+// botLevelFor returns the search depth of the bot closest to a rating,
+// never deeper than botDepth allows for the number of seats: deeper
+// searches on the bigger boards take far too long for a move.
+func botLevelFor(rating float64, seats int) int {
+	best := botLevels[0]
+	for _, level := range botLevels[1:] {
+		if level.depth <= botDepth(seats) && math.Abs(level.rating-rating) < math.Abs(best.rating-rating) {
+			best = level
+		}
+	}
+	return best.depth
+}
+
+// poolEntry is a player waiting in a pool.
+type poolEntry struct {
+	player *Player
+	rating float64 // what the player is matched on: their rating, or their score in an arena
+	avoid  string  // the player's last opponent in an arena
+	joined time.Time
+}
+
+// window is how far from their own rating a waiting player accepts
+// opponents by now.
+func (e *poolEntry) window(now time.Time) float64 {
+	grown := ratingWindow + ratingWindowGrowth*now.Sub(e.joined).Seconds()
+	return math.Min(grown, maxRatingWindow)
+}
+
+// accepts reports whether two waiting players are close enough in rating
+// for both of them. Arena players who just met have to wait a while
+// before they can meet again.
+func (e *poolEntry) accepts(other *poolEntry, now time.Time) bool {
+	if e.avoid == other.player.Username || other.avoid == e.player.Username {
+		if now.Sub(e.joined) < arenaRematchWait || now.Sub(other.joined) < arenaRematchWait {
+			return false
+		}
+	}
+	gap := math.Abs(e.rating - other.rating)
+	return gap <= e.window(now) && gap <= other.window(now)
+}
+
+// pool holds the players waiting for games with one set of options, so
+// every kind of game is matched in its own bucket. Any number of players
+// can wait at once.
+type pool struct {
+	opts    GameOptions
+	mu      sync.Mutex
+	waiting []*poolEntry  // in the order they joined
+	wake    chan struct{} // signalled when somebody joins
+	stop    chan struct{} // closed to stop the pool's matchmaking goroutine
+	avgWait time.Duration // recent time from joining to being matched
+}
+
+// newPool makes a pool for games with the given options and starts its
+// matchmaking goroutine, which runs until the pool is stopped.
+func newPool(opts GameOptions) *pool {
+	q := &pool{opts: opts, wake: make(chan struct{}, 1), stop: make(chan struct{})}
+	go q.run()
+	return q
+}
+
+// poolFor returns the pool for games with the given options. A single,
+// dedicated matchmaking goroutine per pool handles all matchmaking; it is
+// started the first time somebody asks for that kind of game.
+func poolFor(opts GameOptions) *pool {
+	queuesMutex.Lock()
+	defer queuesMutex.Unlock()
+	q, ok := pools[opts]
+	if !ok {
+		q = newPool(opts)
+		pools[opts] = q
+	}
+	return q
+}
+
+// join adds a player to the pool at their current rating and tells them
+// so with QUEUE_JOINED. An arena's pool matches players on their score in
+// the arena instead, and keeps them from meeting their last opponent
+// right away.
+func (q *pool) join(p *Player) {
+	rating := GetRating(p.Username).Rating
+	e := &poolEntry{player: p, rating: rating}
+	if q.opts.arena != nil {
+		score, last := q.opts.arena.matching(p.Username)
+		e.rating, e.avoid = float64(score), last
+	}
+	p.writeMu.Lock()
+	p.pool = q
+	p.writeMu.Unlock()
+	now := time.Now()
+	e.joined = now
+	q.mu.Lock()
+	q.waiting = append(q.waiting, e)
+	msg := q.statusMessage(e, len(q.waiting)-1, now)
+	q.mu.Unlock()
+	log.Printf("Player %s (%.0f) is in the %d-player pool, waiting for opponents or timeout.", p.Username, rating, q.opts.Seats)
+
+	msg["type"] = "QUEUE_JOINED"
+	msg["message"] = "Looking for an opponent..."
+	msg["queue"] = queueInfo(q.opts)
+	msg["rating"] = math.Round(rating)
+	p.Send(msg)
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// remove takes a player out of the pool, reporting whether they were
+// still waiting in it.
+func (q *pool) remove(p *Player) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, e := range q.waiting {
+		if e.player == p {
+			q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// estimate is how much longer a waiting player can expect to wait: the
+// pool's recent average wait, and never past the point where a bot steps
+// in. Must be called with q.mu held.
+func (q *pool) estimate(e *poolEntry, now time.Time) time.Duration {
+	expected := botTimeout
+	if q.avgWait > 0 && q.avgWait < expected {
+		expected = q.avgWait
+	}
+	left := expected - now.Sub(e.joined)
+	if left < 0 {
+		left = 0
+	}
+	return left
+}
+
+// statusMessage is a QUEUE_STATUS message for the player waiting at the
+// given index. Must be called with q.mu held.
+func (q *pool) statusMessage(e *poolEntry, index int, now time.Time) map[string]interface{} {
+	return map[string]interface{}{
+		"type":           "QUEUE_STATUS",
+		"position":       index + 1,
+		"waiting":        len(q.waiting),
+		"waited":         int(now.Sub(e.joined).Seconds()),
+		"estimated_wait": int(math.Ceil(q.estimate(e, now).Seconds())),
+		"rating_window":  int(e.window(now)),
+	}
+}
+
+// sendStatus tells every waiting player where they stand.
+func (q *pool) sendStatus(now time.Time) {
+	q.mu.Lock()
+	players := make([]*Player, len(q.waiting))
+	msgs := make([]map[string]interface{}, len(q.waiting))
+	for i, e := range q.waiting {
+		players[i] = e.player
+		msgs[i] = q.statusMessage(e, i, now)
+	}
+	q.mu.Unlock()
+	for i, p := range players {
+		p.Send(msgs[i])
+	}
+}
+
+// run matches the players in the pool whenever somebody joins and, while
+// anybody is waiting, every poolTick as their rating windows widen. It
+// returns once the pool is stopped.
+func (q *pool) run() {
+	log.Printf("Matchmaker for %+v started...", q.opts)
+	var lastStatus time.Time
+	for {
+		q.mu.Lock()
+		idle := len(q.waiting) == 0
+		q.mu.Unlock()
+		var tick <-chan time.Time
+		if !idle {
+			tick = time.After(poolTick)
+		}
+		select {
+		case <-q.wake:
+		case <-tick:
+		case <-q.stop:
+			return
+		}
+		now := time.Now()
+		for _, players := range q.match(now) {
+			go startGame(players, q.opts)
+		}
+		if now.Sub(lastStatus) >= queueStatusInterval {
+			q.sendStatus(now)
+			lastStatus = now
+		}
+	}
+}
+
+// match takes the games that can be played now out of the pool. Starting
+// with whoever has waited longest, each player is seated with the closest
+// rated players that both accept them. A player still short of opponents
+// after botTimeout gets whoever is in range and bots of matching strength
+// for the remaining seats, except in an arena, where they keep waiting.
+func (q *pool) match(now time.Time) [][]*Player {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	seats := q.opts.Seats
+	taken := make(map[*poolEntry]bool)
+	var games [][]*Player
+	for _, anchor := range q.waiting {
+		if taken[anchor] {
+			continue
+		}
+		var candidates []*poolEntry
+		for _, e := range q.waiting {
+			if e != anchor && !taken[e] && anchor.accepts(e, now) {
+				candidates = append(candidates, e)
+			}
+		}
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return math.Abs(candidates[i].rating-anchor.rating) < math.Abs(candidates[j].rating-anchor.rating)
+		})
+		if len(candidates) > seats-1 {
+			candidates = candidates[:seats-1]
+		}
+		if len(candidates) < seats-1 && (q.opts.arena != nil || now.Sub(anchor.joined) < botTimeout) {
+			continue
+		}
+
+		group := append([]*poolEntry{anchor}, candidates...)
+		players := make([]*Player, 0, seats)
+		total := 0.0
+		for _, e := range group {
+			taken[e] = true
+			q.recordWait(now.Sub(e.joined))
+			players = append(players, e.player)
+			total += e.rating
+		}
+		if len(players) < seats {
+			depth := botLevelFor(total/float64(len(group)), seats)
+			log.Printf("Not enough opponents found for %s, filling %d seat(s) with depth %d bots.", anchor.player.Username, seats-len(players), depth)
+			for n := 1; len(players) < seats; n++ {
+				bot := newBot(n)
+				bot.Depth = depth
+				players = append(players, bot)
+			}
+		}
+		games = append(games, players)
+	}
+
+	if len(taken) > 0 {
+		waiting := q.waiting[:0]
+		for _, e := range q.waiting {
+			if !taken[e] {
+				waiting = append(waiting, e)
+			}
+		}
+		q.waiting = waiting
+	}
+	return games
+}
+
+// recordWait folds one player's wait into the pool's average wait. Must
+// be called with q.mu held.
+func (q *pool) recordWait(wait time.Duration) {
+	if q.avgWait == 0 {
+		q.avgWait = wait
+		return
+	}
+	q.avgWait = (q.avgWait*(waitSamples-1) + wait) / waitSamples
+}
+
+// QueueInfo describes one queue and how many players are waiting in it.
+type QueueInfo struct {
+	Mode        string `json:"mode"`
+	Players     int    `json:"players"`
+	Board       string `json:"board"`
+	Obstacles   string `json:"obstacles,omitempty"`
+	Fog         bool   `json:"fog"`
+	TimeControl string `json:"time_control"`
+	Rated       bool   `json:"rated"`
+	BestOf      int    `json:"best_of,omitempty"`
+	Position    string `json:"position,omitempty"`
+	Waiting     int    `json:"waiting"`
+}
+
+// queueInfo describes the queue for the given options. Players in the
+// low-priority pool are counted with everybody else.
+func queueInfo(opts GameOptions) QueueInfo {
+	info := QueueInfo{
+		Mode:        "ffa",
+		Players:     opts.Seats,
+		Obstacles:   opts.Obstacles,
+		Fog:         opts.Fog,
+		TimeControl: opts.TimeControl,
+		Rated:       !opts.Casual,
+		BestOf:      opts.BestOf,
+		Position:    opts.Position,
+	}
+	if opts.Teams {
+		info.Mode = "teams"
+	}
+	rows, cols := opts.Rows, opts.Cols
+	if rows == 0 {
+		rows, cols = game.BoardSize(opts.Seats)
+	}
+	info.Board = fmt.Sprintf("%dx%d", rows, cols)
+	return info
+}
+
+// queueOptions turns the description of a queue back into its options.
+func queueOptions(info QueueInfo) GameOptions {
+	opts := GameOptions{
+		Seats:       info.Players,
+		Teams:       info.Mode == "teams",
+		Obstacles:   info.Obstacles,
+		Fog:         info.Fog,
+		TimeControl: info.TimeControl,
+		Casual:      !info.Rated,
+		BestOf:      info.BestOf,
+		Position:    info.Position,
+	}
+	var rows, cols int
+	fmt.Sscanf(info.Board, "%dx%d", &rows, &cols)
+	if r, c := game.BoardSize(opts.Seats); rows != r || cols != c {
+		opts.Rows, opts.Cols = rows, cols
+	}
+	return opts
+}
+
+// GetQueues lists every queue somebody has joined since the server
+// started, busiest first.
+func GetQueues() []QueueInfo {
+	waiting := make(map[GameOptions]int)
+	queuesMutex.Lock()
+	all := make([]*pool, 0, len(pools))
+	for _, q := range pools {
+		all = append(all, q)
+	}
+	for opts, n := range teamWaiting {
+		waiting[opts] += n
+	}
+	queuesMutex.Unlock()
+	for _, q := range all {
+		q.mu.Lock()
+		opts := q.opts
+		opts.lowPriority = false
+		waiting[opts] += len(q.waiting)
+		q.mu.Unlock()
+	}
+
+	queues := make([]QueueInfo, 0, len(waiting))
+	for opts, n := range waiting {
+		info := queueInfo(opts)
+		info.Waiting = n
+		queues = append(queues, info)
+	}
+	sort.Slice(queues, func(i, j int) bool {
+		if queues[i].Waiting != queues[j].Waiting {
+			return queues[i].Waiting > queues[j].Waiting
+		}
+		return fmt.Sprint(queues[i]) < fmt.Sprint(queues[j])
+	})
+	return queues
+}
+
+// HandleQueues lists the queues with their waiting counts, e.g. /api/queues
+func HandleQueues(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(GetQueues())
+}
@@ -0,0 +1,313 @@
+package matchmaking
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"Connect-4/internals/handlers/game"
+)
+
+// Players are rated with Glicko-2 (http://www.glicko.net/glicko/glicko2.pdf).
+// Every rated game is a rating period of its own for the players in it;
+// between games a player's rating deviation grows by one step per
+// ratingPeriod they stay away, so the ratings of inactive players become
+// less certain and move faster once they are back.
+const (
+	defaultRating     = 1500.0
+	defaultDeviation  = 350.0
+	defaultVolatility = 0.06
+	minDeviation      = 30.0
+
+	glickoScale = 173.7178 // converts between the Glicko and Glicko-2 scales
+	glickoTau   = 0.5      // how much the volatility may change in one period
+	glickoEps   = 0.000001
+
+	// Bots are not rated; they count as a well known opponent of the
+	// rating their search depth plays at, see botLevels
+	botDeviation = 60.0
+
+	// Ratings with a deviation above this are still provisional
+	provisionalDeviation = 110.0
+)
+
+// Rating is a player's Glicko-2 rating on the familiar Glicko scale.
+type Rating struct {
+	Rating     float64 `json:"rating"`
+	Deviation  float64 `json:"deviation"`
+	Volatility float64 `json:"volatility"`
+}
+
+// newRating is the rating of a player who has not played a rated game.
+func newRating() Rating {
+	return Rating{Rating: defaultRating, Deviation: defaultDeviation, Volatility: defaultVolatility}
+}
+
+// Provisional reports whether too few games are behind the rating to trust it.
+func (r Rating) Provisional() bool {
+	return r.Deviation > provisionalDeviation
+}
+
+// decay returns the rating after the given number of rating periods
+// without a game.
+func (r Rating) decay(periods float64) Rating {
+	if periods <= 0 {
+		return r
+	}
+	phi := r.Deviation / glickoScale
+	sigma := r.Volatility
+	r.Deviation = math.Min(math.Sqrt(phi*phi+periods*sigma*sigma)*glickoScale, defaultDeviation)
+	return r
+}
+
+// glickoResult is one game against one opponent: 1 for a win, 0.5 for a
+// draw and 0 for a loss.
+type glickoResult struct {
+	opponent Rating
+	score    float64
+}
+
+// update returns the rating after a rating period with the given results.
+func (r Rating) update(results []glickoResult) Rating {
+	mu := (r.Rating - defaultRating) / glickoScale
+	phi := r.Deviation / glickoScale
+	sigma := r.Volatility
+	if len(results) == 0 {
+		return r.decay(1)
+	}
+
+	var variance, improvement float64
+	for _, res := range results {
+		muJ := (res.opponent.Rating - defaultRating) / glickoScale
+		phiJ := res.opponent.Deviation / glickoScale
+		g := 1 / math.Sqrt(1+3*phiJ*phiJ/(math.Pi*math.Pi))
+		e := 1 / (1 + math.Exp(-g*(mu-muJ)))
+		variance += g * g * e * (1 - e)
+		improvement += g * (res.score - e)
+	}
+	v := 1 / variance
+	delta := v * improvement
+
+	// New volatility, found with the Illinois algorithm
+	a := math.Log(sigma * sigma)
+	f := func(x float64) float64 {
+		ex := math.Exp(x)
+		d := phi*phi + v + ex
+		return ex*(delta*delta-phi*phi-v-ex)/(2*d*d) - (x-a)/(glickoTau*glickoTau)
+	}
+	A := a
+	var B float64
+	if delta*delta > phi*phi+v {
+		B = math.Log(delta*delta - phi*phi - v)
+	} else {
+		k := 1.0
+		for f(a-k*glickoTau) < 0 {
+			k++
+		}
+		B = a - k*glickoTau
+	}
+	fA, fB := f(A), f(B)
+	for math.Abs(B-A) > glickoEps {
+		C := A + (A-B)*fA/(fB-fA)
+		fC := f(C)
+		if fC*fB <= 0 {
+			A, fA = B, fB
+		} else {
+			fA /= 2
+		}
+		B, fB = C, fC
+	}
+	sigma = math.Exp(A / 2)
+
+	phiStar := math.Sqrt(phi*phi + sigma*sigma)
+	phi = 1 / math.Sqrt(1/(phiStar*phiStar)+1/v)
+	mu += phi * phi * improvement
+
+	return Rating{
+		Rating:     mu*glickoScale + defaultRating,
+		Deviation:  math.Max(phi*glickoScale, minDeviation),
+		Volatility: sigma,
+	}
+}
+
+// RatingChange is how one rated game moved a player's rating.
+type RatingChange struct {
+	GameID   int64  `json:"game_id"`
+	Username string `json:"username"`
+	Before   Rating `json:"before"`
+	After    Rating `json:"after"`
+	Created  string `json:"created_at,omitempty"`
+}
+
+// loadRating returns a player's current rating, decayed for the rating
+// periods they have been away. Must be called with rankMutex held.
+func loadRating(username string) Rating {
+	r := newRating()
+	var ratedAt sql.NullInt64
+	err := db.QueryRow(`SELECT rating, deviation, volatility, rated_at FROM rankings WHERE username = ?`, username).
+		Scan(&r.Rating, &r.Deviation, &r.Volatility, &ratedAt)
+	if err != nil {
+		return newRating()
+	}
+	if ratingPeriod > 0 && ratedAt.Valid {
+		away := time.Since(time.Unix(ratedAt.Int64, 0))
+		r = r.decay(math.Floor(float64(away) / float64(ratingPeriod)))
+	}
+	return r
+}
+
+// rateGame updates the rating of every human in a finished rated game and
+// stores the before and after values under the saved game's id. Every
+// player is scored against each opponent on another side: a win against
+// those they finished ahead of, a draw against those they tied with.
+func rateGame(s *GameSession, gameID int64) map[string]RatingChange {
+	g := s.Game
+	rankMutex.Lock()
+	defer rankMutex.Unlock()
+
+	before := make([]Rating, len(s.Players))
+	for i, p := range s.Players {
+		if p.Bot {
+			depth := p.searchDepth(len(s.Players))
+			before[i] = Rating{Rating: botLevelRating(depth), Deviation: botDeviation, Volatility: defaultVolatility}
+		} else {
+			before[i] = loadRating(p.Username)
+		}
+	}
+
+	changes := make(map[string]RatingChange)
+	now := time.Now().Unix()
+	for i, p := range s.Players {
+		if p.Bot {
+			continue
+		}
+		var results []glickoResult
+		for j := range s.Players {
+			if g.Piece(j+1) == g.Piece(i+1) {
+				continue
+			}
+			score := 0.5
+			if g.Places[i] < g.Places[j] {
+				score = 1
+			} else if g.Places[i] > g.Places[j] {
+				score = 0
+			}
+			results = append(results, glickoResult{opponent: before[j], score: score})
+		}
+		after := before[i].update(results)
+		changes[p.Username] = RatingChange{GameID: gameID, Username: p.Username, Before: before[i], After: after}
+
+		_, err := db.Exec(`
+			INSERT INTO rankings (username, rating, deviation, volatility, rated_games, rated_at)
+			VALUES (?, ?, ?, ?, 1, ?)
+			ON CONFLICT(username) DO UPDATE SET rating = excluded.rating, deviation = excluded.deviation,
+				volatility = excluded.volatility, rated_games = rated_games + 1, rated_at = excluded.rated_at
+		`, p.Username, after.Rating, after.Deviation, after.Volatility, now)
+		if err != nil {
+			log.Printf("Error updating rating for %s: %v", p.Username, err)
+			continue
+		}
+		_, err = db.Exec(`
+			INSERT INTO rating_changes (game_id, username, rating_before, deviation_before, volatility_before,
+				rating_after, deviation_after, volatility_after)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		`, gameID, p.Username, before[i].Rating, before[i].Deviation, before[i].Volatility,
+			after.Rating, after.Deviation, after.Volatility)
+		if err != nil {
+			log.Printf("Error saving rating change for %s: %v", p.Username, err)
+		}
+	}
+	return changes
+}
+
+// penaliseRating takes rating points off a player outside of a game.
+func penaliseRating(username string, points float64) {
+	rankMutex.Lock()
+	defer rankMutex.Unlock()
+
+	_, err := db.Exec(`UPDATE rankings SET rating = rating - ? WHERE username = ?`, points, username)
+	if err != nil {
+		log.Printf("Error penalising %s: %v", username, err)
+	}
+}
+
+// GetRating returns a player's current rating.
+func GetRating(username string) Rating {
+	rankMutex.Lock()
+	defer rankMutex.Unlock()
+	return loadRating(username)
+}
+
+// GetRatingHistory returns a player's most recent rating changes.
+func GetRatingHistory(username string, limit int) []RatingChange {
+	rankMutex.Lock()
+	defer rankMutex.Unlock()
+
+	rows, err := db.Query(`
+		SELECT game_id, username, rating_before, deviation_before, volatility_before,
+			rating_after, deviation_after, volatility_after, IFNULL(created_at, '')
+		FROM rating_changes WHERE username = ?
+		ORDER BY id DESC LIMIT ?
+	`, username, limit)
+	if err != nil {
+		log.Printf("Error fetching rating history: %v", err)
+		return nil
+	}
+	defer rows.Close()
+
+	history := []RatingChange{}
+	for rows.Next() {
+		var c RatingChange
+		if err := rows.Scan(&c.GameID, &c.Username, &c.Before.Rating, &c.Before.Deviation, &c.Before.Volatility,
+			&c.After.Rating, &c.After.Deviation, &c.After.Volatility, &c.Created); err != nil {
+			log.Println("Error scanning row:", err)
+			continue
+		}
+		history = append(history, c)
+	}
+	return history
+}
+
+// HandleRatingHistory returns a player's current rating and how their
+// recent rated games changed it, e.g. /api/ratings?username=alice&limit=20
+func HandleRatingHistory(w http.ResponseWriter, r *http.Request) {
+	username := r.URL.Query().Get("username")
+	if username == "" {
+		http.Error(w, "Username required", http.StatusBadRequest)
+		return
+	}
+	limit := 50
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"username": username,
+		"rating":   GetRating(username),
+		"history":  GetRatingHistory(username, limit),
+	})
+}
+
+// ratingsMessage lists the rating changes of a game for GAME_OVER.
+func ratingsMessage(g *game.Game, changes map[string]RatingChange) map[string]interface{} {
+	msg := make(map[string]interface{})
+	for _, name := range g.Players {
+		if c, ok := changes[name]; ok {
+			msg[name] = map[string]interface{}{
+				"before": math.Round(c.Before.Rating),
+				"after":  math.Round(c.After.Rating),
+				"change": math.Round(c.After.Rating) - math.Round(c.Before.Rating),
+			}
+		}
+	}
+	return msg
+}
@@ -0,0 +1,65 @@
+package matchmaking
+
+import (
+	"math"
+	"testing"
+)
+
+func TestRatingUpdate(t *testing.T) {
+	tests := []struct {
+		name    string
+		rating  Rating
+		results []glickoResult
+		want    Rating
+	}{
+		{
+			// The worked example in section 3 of Glickman's Glicko-2 paper
+			name:   "glickman example",
+			rating: Rating{Rating: 1500, Deviation: 200, Volatility: 0.06},
+			results: []glickoResult{
+				{opponent: Rating{Rating: 1400, Deviation: 30}, score: 1},
+				{opponent: Rating{Rating: 1550, Deviation: 100}, score: 0},
+				{opponent: Rating{Rating: 1700, Deviation: 300}, score: 0},
+			},
+			want: Rating{Rating: 1464.06, Deviation: 151.52, Volatility: 0.05999},
+		},
+		{
+			name:   "no games only grows the deviation",
+			rating: Rating{Rating: 1500, Deviation: 200, Volatility: 0.06},
+			want:   Rating{Rating: 1500, Deviation: 200.27, Volatility: 0.06},
+		},
+		{
+			name:   "draw between equals keeps the rating",
+			rating: Rating{Rating: 1500, Deviation: 350, Volatility: 0.06},
+			results: []glickoResult{
+				{opponent: Rating{Rating: 1500, Deviation: 350}, score: 0.5},
+			},
+			want: Rating{Rating: 1500, Deviation: 290.32, Volatility: 0.06},
+		},
+		{
+			name:   "deviation never drops below the floor",
+			rating: Rating{Rating: 2000, Deviation: 20, Volatility: 0.06},
+			results: []glickoResult{
+				{opponent: Rating{Rating: 2000, Deviation: 20}, score: 0.5},
+			},
+			want: Rating{Rating: 2000, Deviation: minDeviation, Volatility: 0.06},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.rating.update(tt.results)
+			if math.Abs(got.Rating-tt.want.Rating) > 0.01 ||
+				math.Abs(got.Deviation-tt.want.Deviation) > 0.01 ||
+				math.Abs(got.Volatility-tt.want.Volatility) > 0.00001 {
+				t.Errorf("update() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRatingDecayIsCapped(t *testing.T) {
+	r := Rating{Rating: 1800, Deviation: 100, Volatility: 0.06}.decay(10000)
+	if r.Deviation != defaultDeviation || r.Rating != 1800 {
+		t.Errorf("decay(10000) = %+v, want deviation %v and rating 1800", r, defaultDeviation)
+	}
+}
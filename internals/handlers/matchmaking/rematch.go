@@ -0,0 +1,184 @@
+package matchmaking
+
+import (
+	"fmt"
+	"log"
+
+	"Connect-4/internals/handlers/game"
+)
+
+// seriesScore is the running score between players who keep playing each
+// other, through rematches or in a best-of-N series: a point for a win,
+// half a point each for a shared first place.
+type seriesScore struct {
+	ID      int64              `json:"id,omitempty"`      // row in the series table, 0 for rematches
+	BestOf  int                `json:"best_of,omitempty"` // 0 for rematches, which go on as long as the players like
+	Players []string           `json:"players"`           // in the seat order of the first game
+	Games   int                `json:"games"`
+	Points  map[string]float64 `json:"points"`
+}
+
+// after returns the score with the result of g added. It may be called on
+// a nil score to start counting.
+func (sc *seriesScore) after(g *game.Game) *seriesScore {
+	next := &seriesScore{Players: append([]string{}, g.Players...), Points: make(map[string]float64)}
+	if sc != nil {
+		next.ID, next.BestOf, next.Players, next.Games = sc.ID, sc.BestOf, sc.Players, sc.Games
+		for name, points := range sc.Points {
+			next.Points[name] = points
+		}
+	}
+	if g.State == game.StateAborted {
+		return next // an aborted game does not count
+	}
+	next.Games++
+
+	firsts := map[int]bool{}
+	for i, place := range g.Places {
+		if place == 1 {
+			firsts[g.Piece(i+1)] = true
+		}
+	}
+	for i, name := range g.Players {
+		points := 0.0
+		if g.Places[i] == 1 {
+			points = 1 / float64(len(firsts))
+		}
+		next.Points[name] += points
+	}
+	return next
+}
+
+// answerRematch handles what a player sends once the game is over:
+// REMATCH_OFFER or REMATCH_ACCEPT to ask for another game, REMATCH_DECLINE
+// to turn it down. Bots are always up for a rematch. Once everybody agrees
+// the new game starts on the same connections.
+func (s *GameSession) answerRematch(p *Player, kind string) {
+	if kind != "REMATCH_OFFER" && kind != "REMATCH_ACCEPT" && kind != "REMATCH_DECLINE" {
+		return
+	}
+	g := s.Game
+	g.Mutex.Lock()
+	if s.rematchClosed {
+		g.Mutex.Unlock()
+		p.Send(map[string]interface{}{
+			"type":    "REMATCH_DECLINED",
+			"message": "The rematch is no longer available.",
+		})
+		return
+	}
+	if kind == "REMATCH_DECLINE" {
+		s.rematchClosed = true
+		g.Mutex.Unlock()
+		log.Printf("Game %s: %s declined a rematch.", g.ID, p.Username)
+		s.broadcast(map[string]interface{}{
+			"type":    "REMATCH_DECLINED",
+			"message": fmt.Sprintf("%s declined a rematch.", p.Username),
+			"player":  p.Username,
+		})
+		return
+	}
+
+	if s.rematch == nil {
+		s.rematch = make(map[*Player]bool)
+	}
+	s.rematch[p] = true
+	for _, other := range s.Players {
+		if !other.Bot && !s.rematch[other] {
+			g.Mutex.Unlock()
+			log.Printf("Game %s: %s wants a rematch.", g.ID, p.Username)
+			s.broadcast(map[string]interface{}{
+				"type":    "REMATCH_OFFERED",
+				"message": fmt.Sprintf("%s wants a rematch.", p.Username),
+				"player":  p.Username,
+			})
+			return
+		}
+	}
+	s.rematchClosed = true
+	g.Mutex.Unlock()
+
+	s.startRematch()
+}
+
+// leaveRematch takes a player who left after the game out of any rematch.
+func (s *GameSession) leaveRematch(p *Player) {
+	g := s.Game
+	g.Mutex.Lock()
+	if s.rematchClosed {
+		g.Mutex.Unlock()
+		return
+	}
+	s.rematchClosed = true
+	g.Mutex.Unlock()
+
+	s.broadcast(map[string]interface{}{
+		"type":    "REMATCH_DECLINED",
+		"message": fmt.Sprintf("%s has left.", p.Username),
+		"player":  p.Username,
+	})
+}
+
+// startRematch starts a new game between the same players, keeping the
+// running score.
+func (s *GameSession) startRematch() {
+	g := s.Game
+	g.Mutex.Lock()
+	series := s.series.after(g)
+	g.Mutex.Unlock()
+
+	left, err := s.startNext(series)
+	switch {
+	case left != nil:
+		s.broadcast(map[string]interface{}{
+			"type":    "REMATCH_DECLINED",
+			"message": fmt.Sprintf("%s has left.", left.Username),
+			"player":  left.Username,
+		})
+	case err != nil:
+		s.broadcast(map[string]interface{}{
+			"type":    "REMATCH_DECLINED",
+			"message": "The rematch could not be set up.",
+		})
+	}
+}
+
+// startNext starts the next game between the session's players with the
+// seats rotated by one, so in a two-player game the colours and the first
+// move swap. It skips the queue and carries the series score on. If a
+// player has left in the meantime nothing starts and that player is
+// returned.
+func (s *GameSession) startNext(series *seriesScore) (*Player, error) {
+	for _, p := range s.Players {
+		if !p.connected() {
+			return p, nil
+		}
+	}
+
+	players := append(append([]*Player{}, s.Players[1:]...), s.Players[0])
+	names := make([]string, len(players))
+	for i, p := range players {
+		names[i] = p.Username
+	}
+
+	old := s.Game
+	old.Mutex.Lock()
+	g, err := old.Rematch(newGameID(names[0]), names)
+	old.Mutex.Unlock()
+	if err != nil {
+		log.Printf("Game %s: could not set up the next game: %v", old.ID, err)
+		return nil, err
+	}
+	for i, p := range players {
+		p.ID = i + 1
+	}
+
+	log.Printf("Game %s: next game %s between %v, game %d of the series.", old.ID, g.ID, names, series.Games+1)
+	startSession(&GameSession{
+		Game:           g,
+		Players:        players,
+		readersRunning: true,
+		series:         series,
+	})
+	return nil, nil
+}
@@ -0,0 +1,54 @@
+package matchmaking
+
+import (
+	"Connect-4/internals/handlers/game"
+	"fmt"
+	"log"
+	"strconv"
+)
+
+// newSandboxGame seats the player in the custom starting position checked
+// by parseGameOptions, with bots in every other seat. Unless a seat is
+// asked for, the player takes the side to move. Sandbox games are casual.
+func newSandboxGame(username string, opts GameOptions, seatParam string) (*game.Game, error) {
+	g, err := game.NewGameFromPosition(newGameID(username), make([]string, opts.Seats), opts.Position)
+	if err != nil {
+		return nil, err
+	}
+	seat := g.Turn
+	if seatParam != "" {
+		seat, err = strconv.Atoi(seatParam)
+		if err != nil || seat < 1 || seat > opts.Seats {
+			return nil, fmt.Errorf("seat must be between 1 and %d", opts.Seats)
+		}
+	}
+
+	bots := 0
+	for i := range g.Players {
+		if i+1 == seat {
+			g.Players[i] = username
+		} else {
+			bots++
+			g.Players[i] = newBot(bots).Username
+		}
+	}
+	g.Fog = opts.Fog
+	g.Casual = true
+	setClock(g, opts)
+	return g, nil
+}
+
+// startSandboxGame starts a game created by newSandboxGame right away.
+func startSandboxGame(g *game.Game, human *Player) {
+	players := make([]*Player, len(g.Players))
+	for i, name := range g.Players {
+		if name == human.Username {
+			players[i] = human
+		} else {
+			players[i] = &Player{Username: name, Bot: true}
+		}
+		players[i].ID = i + 1
+	}
+	log.Printf("Player %s started a sandbox game %s from %s", human.Username, g.ID, g.Notation())
+	runGame(g, players)
+}
@@ -0,0 +1,278 @@
+package matchmaking
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"Connect-4/internals/handlers/game"
+)
+
+const (
+	// Longest series a player can ask for
+	maxBestOf = 9
+
+	// Sudden-death games played when a series is level after its N games,
+	// before it is called a draw
+	seriesTiebreaks = 2
+
+	// Pause between the games of a series
+	seriesPause = 5 * time.Second
+)
+
+// startSeries records a new best-of-N series between two players, listed
+// in the seat order of the first game.
+func startSeries(players []string, bestOf int) *seriesScore {
+	sc := &seriesScore{BestOf: bestOf, Players: players, Points: make(map[string]float64)}
+	rankMutex.Lock()
+	defer rankMutex.Unlock()
+	res, err := db.Exec(`INSERT INTO series (player1, player2, best_of) VALUES (?, ?, ?)`,
+		players[0], players[1], bestOf)
+	if err != nil {
+		log.Printf("Error saving series: %v", err)
+		return sc
+	}
+	sc.ID, _ = res.LastInsertId()
+	return sc
+}
+
+// saveSeries stores the score of a series, and its winner ("draw" for a
+// drawn series) once it is over.
+func saveSeries(sc *seriesScore, status, winner string) {
+	if sc.ID == 0 {
+		return
+	}
+	rankMutex.Lock()
+	defer rankMutex.Unlock()
+	_, err := db.Exec(`
+		UPDATE series SET games = ?, score1 = ?, score2 = ?, winner = NULLIF(?, ''), status = ?,
+			finished_at = CASE WHEN ? = 'active' THEN NULL ELSE CURRENT_TIMESTAMP END
+		WHERE id = ?
+	`, sc.Games, sc.Points[sc.Players[0]], sc.Points[sc.Players[1]], winner, status, status, sc.ID)
+	if err != nil {
+		log.Printf("Error updating series %d: %v", sc.ID, err)
+	}
+}
+
+// decided reports whether the series is over and who won it. A player wins
+// as soon as they have more than half of the N points. A series that is
+// level after N games goes to sudden-death games, and is a draw if it is
+// still level after seriesTiebreaks of them.
+func (sc *seriesScore) decided() (winner string, over bool) {
+	leader, best, level := "", -1.0, false
+	for _, name := range sc.Players {
+		switch points := sc.Points[name]; {
+		case points > best:
+			leader, best, level = name, points, false
+		case points == best:
+			level = true
+		}
+	}
+	if !level && best > float64(sc.BestOf)/2 {
+		return leader, true
+	}
+	if sc.Games >= sc.BestOf+seriesTiebreaks {
+		return "draw", true
+	}
+	return "", false
+}
+
+// score writes the series score as "2-1", in the order of the first game.
+func (sc *seriesScore) score() string {
+	return fmt.Sprintf("%g-%g", sc.Points[sc.Players[0]], sc.Points[sc.Players[1]])
+}
+
+// continueSeries runs after each game of a best-of-N series: it records the
+// result and either ends the series or starts the next game on the same
+// connections after a short pause. A player who has left by then forfeits
+// the series.
+func (s *GameSession) continueSeries() {
+	g := s.Game
+	g.Mutex.Lock()
+	next := s.series.after(g)
+	aborted := g.State == game.StateAborted
+	g.Mutex.Unlock()
+
+	if aborted {
+		s.endSeries(next, "", "abandoned")
+		return
+	}
+	if winner, over := next.decided(); over {
+		s.endSeries(next, winner, "finished")
+		return
+	}
+	saveSeries(next, "active", "")
+	message := fmt.Sprintf("Series score %s. Game %d starts in %d seconds.", next.score(), next.Games+1, int(seriesPause.Seconds()))
+	if next.Games >= next.BestOf {
+		message = fmt.Sprintf("Series level at %s. Sudden-death game starts in %d seconds.", next.score(), int(seriesPause.Seconds()))
+	}
+	s.broadcast(map[string]interface{}{
+		"type":    "SERIES_UPDATE",
+		"message": message,
+		"series":  next,
+	})
+
+	time.Sleep(seriesPause)
+	left, err := s.startNext(next)
+	switch {
+	case left != nil:
+		winner := next.Players[0]
+		if winner == left.Username {
+			winner = next.Players[1]
+		}
+		log.Printf("Series %d: %s left, %s wins the series.", next.ID, left.Username, winner)
+		s.endSeries(next, winner, "abandoned")
+	case err != nil:
+		s.endSeries(next, "", "abandoned")
+	}
+}
+
+// endSeries records the end of a series and tells the players.
+func (s *GameSession) endSeries(sc *seriesScore, winner, status string) {
+	saveSeries(sc, status, winner)
+	message := fmt.Sprintf("%s wins the series %s.", winner, sc.score())
+	switch winner {
+	case "draw":
+		message = fmt.Sprintf("The series is drawn %s.", sc.score())
+	case "":
+		message = "The series was abandoned."
+	}
+	log.Printf("Series %d over: %s", sc.ID, message)
+	s.broadcast(map[string]interface{}{
+		"type":    "SERIES_OVER",
+		"message": message,
+		"winner":  winner,
+		"status":  status,
+		"series":  sc,
+	})
+}
+
+// SeriesResult is a best-of-N series as returned by the API.
+type SeriesResult struct {
+	ID       int64   `json:"id"`
+	Player1  string  `json:"player1"`
+	Player2  string  `json:"player2"`
+	BestOf   int     `json:"best_of"`
+	Games    int     `json:"games"`
+	Score1   float64 `json:"score1"`
+	Score2   float64 `json:"score2"`
+	Winner   string  `json:"winner"`
+	Status   string  `json:"status"`
+	GameIDs  []int64 `json:"game_ids"`
+	Created  string  `json:"created_at"`
+	Finished string  `json:"finished_at"`
+}
+
+// GetSeries returns the most recent series, optionally only those a user
+// played in, together with the games of each.
+func GetSeries(username string, limit int) []SeriesResult {
+	rankMutex.Lock()
+	defer rankMutex.Unlock()
+
+	rows, err := db.Query(`
+		SELECT id, player1, player2, best_of, games, score1, score2, IFNULL(winner, ''), status,
+			IFNULL(created_at, ''), IFNULL(finished_at, '')
+		FROM series
+		WHERE ? = '' OR player1 = ? OR player2 = ?
+		ORDER BY id DESC LIMIT ?
+	`, username, username, username, limit)
+	if err != nil {
+		log.Printf("Error fetching series: %v", err)
+		return nil
+	}
+	results := []SeriesResult{}
+	for rows.Next() {
+		var sr SeriesResult
+		if err := rows.Scan(&sr.ID, &sr.Player1, &sr.Player2, &sr.BestOf, &sr.Games, &sr.Score1, &sr.Score2,
+			&sr.Winner, &sr.Status, &sr.Created, &sr.Finished); err != nil {
+			log.Println("Error scanning row:", err)
+			continue
+		}
+		results = append(results, sr)
+	}
+	rows.Close()
+
+	for i := range results {
+		results[i].GameIDs = []int64{}
+		games, err := db.Query(`SELECT id FROM games WHERE series_id = ? ORDER BY id`, results[i].ID)
+		if err != nil {
+			log.Printf("Error fetching games of series %d: %v", results[i].ID, err)
+			continue
+		}
+		for games.Next() {
+			var id int64
+			if err := games.Scan(&id); err == nil {
+				results[i].GameIDs = append(results[i].GameIDs, id)
+			}
+		}
+		games.Close()
+	}
+	return results
+}
+
+// HandleSeries lists recent series, e.g. /api/series?username=alice&limit=20
+func HandleSeries(w http.ResponseWriter, r *http.Request) {
+	limit := 50
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(GetSeries(r.URL.Query().Get("username"), limit))
+}
+
+// SeriesStanding is one player's record in finished series.
+type SeriesStanding struct {
+	Username string `json:"username"`
+	Won      int    `json:"won"`
+	Drawn    int    `json:"drawn"`
+	Lost     int    `json:"lost"`
+}
+
+// GetSeriesRanking ranks players by the series they won, then by fewest
+// series lost. It is kept apart from the single-game rankings.
+func GetSeriesRanking() []SeriesStanding {
+	rankMutex.Lock()
+	defer rankMutex.Unlock()
+
+	rows, err := db.Query(`
+		SELECT username,
+			SUM(winner = username), SUM(winner = 'draw'), SUM(winner != username AND winner != 'draw')
+		FROM (
+			SELECT player1 AS username, winner FROM series WHERE winner IS NOT NULL
+			UNION ALL
+			SELECT player2 AS username, winner FROM series WHERE winner IS NOT NULL
+		)
+		GROUP BY username
+		ORDER BY 2 DESC, 4 ASC, username ASC
+	`)
+	if err != nil {
+		log.Printf("Error fetching series rankings: %v", err)
+		return nil
+	}
+	defer rows.Close()
+
+	ranking := []SeriesStanding{}
+	for rows.Next() {
+		var st SeriesStanding
+		if err := rows.Scan(&st.Username, &st.Won, &st.Drawn, &st.Lost); err != nil {
+			log.Println("Error scanning row:", err)
+			continue
+		}
+		ranking = append(ranking, st)
+	}
+	return ranking
+}
+
+// HandleSeriesRanking returns the series rankings.
+func HandleSeriesRanking(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(GetSeriesRanking())
+}
@@ -0,0 +1,190 @@
+package matchmaking
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// watchers returns the spectators watching the game.
+func (s *GameSession) watchers() []*Player {
+	s.spectatorMu.Lock()
+	defer s.spectatorMu.Unlock()
+	list := make([]*Player, 0, len(s.spectators))
+	for w := range s.spectators {
+		list = append(list, w)
+	}
+	return list
+}
+
+// spectatorNames lists who is watching, sorted by name.
+func (s *GameSession) spectatorNames() []string {
+	names := []string{}
+	for _, w := range s.watchers() {
+		names = append(names, w.Username)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// announceSpectators tells everybody in the game how many are watching.
+func (s *GameSession) announceSpectators() {
+	names := s.spectatorNames()
+	s.broadcast(map[string]interface{}{
+		"type":       "SPECTATORS",
+		"count":      len(names),
+		"spectators": names,
+		"allowed":    s.spectatingAllowed(),
+	})
+}
+
+// spectatingAllowed reports whether no seat has turned spectating off.
+func (s *GameSession) spectatingAllowed() bool {
+	s.spectatorMu.Lock()
+	defer s.spectatorMu.Unlock()
+	return len(s.noSpectators) == 0
+}
+
+// allowSpectators turns spectating on or off for a seat. It stays off
+// while any seat wants it off, and turning it off sends every spectator
+// away.
+func (s *GameSession) allowSpectators(seat int, allow bool) {
+	s.spectatorMu.Lock()
+	if s.noSpectators == nil {
+		s.noSpectators = make(map[int]bool)
+	}
+	if allow {
+		delete(s.noSpectators, seat)
+	} else {
+		s.noSpectators[seat] = true
+	}
+	s.spectatorMu.Unlock()
+
+	if !allow {
+		s.dismissSpectators(fmt.Sprintf("%s turned spectating off.", s.Players[seat-1].Username))
+		wakeTV()
+	}
+	s.announceSpectators()
+}
+
+// dismissSpectators tells every spectator why they have to go and closes
+// their connections.
+func (s *GameSession) dismissSpectators(message string) {
+	s.spectatorMu.Lock()
+	watchers := s.spectators
+	s.spectators = nil
+	s.spectatorMu.Unlock()
+	for w := range watchers {
+		w.Send(map[string]interface{}{"type": "SPECTATING_ENDED", "message": message})
+		w.Conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+		w.Conn.Close()
+	}
+}
+
+// watchMessage builds the WATCH_START message with everything a spectator
+// needs to follow the game from here. Call it with the game locked.
+func (s *GameSession) watchMessage(count int) map[string]interface{} {
+	g := s.Game
+	return map[string]interface{}{
+		"type":         "WATCH_START",
+		"game_id":      g.ID,
+		"state":        g.State,
+		"board":        g.ViewFor(0),
+		"moves":        g.Moves,
+		"next_turn":    g.Turn,
+		"players":      g.Players,
+		"teams":        g.Teams,
+		"colours":      g.Colours(),
+		"rated":        g.Rated(),
+		"time_control": g.TimeControl(),
+		"clocks":       g.Clocks(time.Now()),
+		"layout":       g.LayoutName,
+		"obstacles":    g.Obstacles,
+		"series":       s.series,
+		"spectators":   count,
+	}
+}
+
+// HandleWatch lets a spectator follow a live game, e.g.
+// /ws/watch?game_id=211552alice&username=carol. The spectator gets the
+// board, the moves so far and the clocks, then every event the players get.
+// Fog-of-war games cannot be watched, since a spectator could tell a
+// player what they are not meant to see.
+func HandleWatch(w http.ResponseWriter, r *http.Request) {
+	gameID := r.URL.Query().Get("game_id")
+	mutex.Lock()
+	s, ok := games[gameID]
+	mutex.Unlock()
+	if !ok {
+		http.Error(w, "Game not found", http.StatusNotFound)
+		return
+	}
+	if s.Game.Fog {
+		http.Error(w, "Fog of war games cannot be watched", http.StatusForbidden)
+		return
+	}
+	if !s.spectatingAllowed() {
+		http.Error(w, "The players turned spectating off", http.StatusForbidden)
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Println("Upgrade error:", err)
+		return
+	}
+	username := r.URL.Query().Get("username")
+	if username == "" {
+		username = "anonymous"
+	}
+	// Live events are held back until the snapshot is written
+	watcher := &Player{Username: username, Conn: conn}
+	watcher.hold()
+
+	// Take the snapshot and join under the game lock, so no move is missed
+	// in between, but write it after unlocking so a slow spectator does not
+	// hold up the game
+	var snapshot map[string]interface{}
+	s.Game.Mutex.Lock()
+	joined := !s.Game.Over() && s.spectatingAllowed()
+	if joined {
+		snapshot = s.watchMessage(len(s.watchers()) + 1)
+		s.spectatorMu.Lock()
+		if joined = len(s.noSpectators) == 0; joined {
+			if s.spectators == nil {
+				s.spectators = make(map[*Player]bool)
+			}
+			s.spectators[watcher] = true
+		}
+		s.spectatorMu.Unlock()
+	}
+	s.Game.Mutex.Unlock()
+
+	if !joined {
+		watcher.release(map[string]interface{}{"type": "SPECTATING_ENDED", "message": "The game can no longer be watched."})
+		conn.Close()
+		return
+	}
+	watcher.release(snapshot)
+	log.Printf("%s is watching game %s.", username, gameID)
+	s.announceSpectators()
+
+	// Spectators only listen; reading just notices the socket closing
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			break
+		}
+	}
+	s.spectatorMu.Lock()
+	_, still := s.spectators[watcher]
+	delete(s.spectators, watcher)
+	s.spectatorMu.Unlock()
+	conn.Close()
+	if still && !s.isDone() {
+		s.announceSpectators()
+	}
+}
@@ -0,0 +1,240 @@
+package matchmaking
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	teamSeats = 4
+	teamSize  = 2
+
+	// How long the first half of a premade duo waits for their partner
+	// before queueing alone.
+	partnerTimeout = 60 * time.Second
+)
+
+// pendingDuo is half of a premade duo waiting for their partner to connect.
+type pendingDuo struct {
+	player  *Player
+	partner string
+	opts    GameOptions
+	timer   *time.Timer
+}
+
+var (
+	// Parties (a solo player or a premade duo) waiting for a 2v2 game, one
+	// queue per set of game options
+	teamQueues  = make(map[GameOptions]chan []*Player)
+	teamWaiting = make(map[GameOptions]int) // players in each team queue, guarded by queuesMutex
+	pendingDuos = make(map[string]*pendingDuo)
+	duoMutex    sync.Mutex // To protect pendingDuos
+)
+
+// teamQueueFor returns the 2v2 queue for the given options, starting its
+// TeamMatchmaker the first time somebody asks for it.
+func teamQueueFor(opts GameOptions) chan []*Player {
+	queuesMutex.Lock()
+	defer queuesMutex.Unlock()
+	queue, ok := teamQueues[opts]
+	if !ok {
+		queue = make(chan []*Player, teamSeats)
+		teamQueues[opts] = queue
+		go TeamMatchmaker(opts, queue)
+	}
+	return queue
+}
+
+// joinTeamQueue puts a player into the 2v2 queue. A player naming a partner
+// waits until the partner connects naming them back, and the two are then
+// queued as one party that always ends up on the same team.
+// Both halves of the duo must ask for the same game options.
+func joinTeamQueue(p *Player, partner string, opts GameOptions) {
+	queue := teamQueueFor(opts)
+	if partner == "" || partner == p.Username {
+		queueParty(queue, []*Player{p}, opts)
+		return
+	}
+
+	duoMutex.Lock()
+	if mate, ok := pendingDuos[partner]; ok && mate.partner == p.Username && mate.opts == opts {
+		delete(pendingDuos, partner)
+		duoMutex.Unlock()
+		mate.timer.Stop()
+		log.Printf("Premade duo %s & %s joined the team queue.", partner, p.Username)
+		queueParty(queue, []*Player{mate.player, p}, opts)
+		return
+	}
+	duo := &pendingDuo{player: p, partner: partner, opts: opts}
+	duo.timer = time.AfterFunc(partnerTimeout, func() {
+		duoMutex.Lock()
+		if pendingDuos[p.Username] != duo {
+			duoMutex.Unlock()
+			return
+		}
+		delete(pendingDuos, p.Username)
+		duoMutex.Unlock()
+		log.Printf("%s's partner %s never arrived, queueing %s alone.", p.Username, partner, p.Username)
+		queueParty(queue, []*Player{p}, opts)
+	})
+	pendingDuos[p.Username] = duo
+	duoMutex.Unlock()
+
+	p.Send(map[string]interface{}{
+		"type":    "WAITING_FOR_PARTNER",
+		"message": fmt.Sprintf("Waiting for %s to join...", partner),
+		"partner": partner,
+	})
+}
+
+// leaveDuo takes a player who is still waiting for their partner out of
+// pendingDuos, so the partner does not team up with somebody who is gone.
+func leaveDuo(p *Player) {
+	duoMutex.Lock()
+	duo, ok := pendingDuos[p.Username]
+	if !ok || duo.player != p {
+		duoMutex.Unlock()
+		return
+	}
+	delete(pendingDuos, p.Username)
+	duoMutex.Unlock()
+	duo.timer.Stop()
+	log.Printf("%s left while waiting for their partner %s.", p.Username, duo.partner)
+}
+
+// queueParty puts a party into a team queue and tells its players.
+func queueParty(queue chan []*Player, party []*Player, opts GameOptions) {
+	for _, p := range party {
+		p.Send(map[string]interface{}{
+			"type":           "QUEUE_JOINED",
+			"message":        "Looking for opponents...",
+			"queue":          queueInfo(opts),
+			"estimated_wait": int(botTimeout.Seconds()),
+		})
+	}
+	queue <- party
+}
+
+// dropLeavers takes the players who left the queue out of the waiting
+// parties. What is left of a premade duo carries on as a solo player.
+func dropLeavers(waiting [][]*Player) [][]*Player {
+	var kept [][]*Player
+	for _, party := range waiting {
+		var stayed []*Player
+		for _, p := range party {
+			if !p.hasLeft() {
+				stayed = append(stayed, p)
+			}
+		}
+		if len(stayed) > 0 {
+			kept = append(kept, stayed)
+		}
+	}
+	return kept
+}
+
+// TeamMatchmaker forms two teams of two out of the parties in queue.
+// Premade duos always play together; solo players are paired up. When the
+// timeout runs out the empty slots are filled with bots.
+func TeamMatchmaker(opts GameOptions, queue chan []*Player) {
+	log.Printf("Team matchmaker for %+v started...", opts)
+	var waiting [][]*Player
+	for {
+		for waiting = dropLeavers(waiting); len(waiting) == 0; waiting = dropLeavers(waiting) {
+			waiting = append(waiting, <-queue)
+		}
+		log.Printf("%s is in the team queue, waiting for opponents or timeout.", waiting[0][0].Username)
+		countTeamWaiting(opts, waiting)
+
+		timeout := time.After(botTimeout)
+		var teamA, teamB []*Player
+	gather:
+		for {
+			var ok bool
+			waiting = dropLeavers(waiting)
+			if teamA, teamB, waiting, ok = formTeams(waiting, false); ok {
+				break
+			}
+			select {
+			case party := <-queue:
+				waiting = append(waiting, party)
+				countTeamWaiting(opts, waiting)
+			case <-timeout:
+				if waiting = dropLeavers(waiting); len(waiting) > 0 {
+					teamA, teamB, waiting, _ = formTeams(waiting, true)
+				}
+				break gather
+			}
+		}
+		if teamA == nil {
+			countTeamWaiting(opts, waiting) // everybody left
+			continue
+		}
+
+		log.Printf("Team match found: %s vs %s", teamLabel(teamA), teamLabel(teamB))
+		countTeamWaiting(opts, waiting)
+		// Seats rotate A1, B1, A2, B2
+		go startGame([]*Player{teamA[0], teamB[0], teamA[1], teamB[1]}, opts)
+	}
+}
+
+// formTeams tries to build two full teams from the waiting parties, oldest
+// first, placing duos before solo players so a late duo never gets split.
+// With fillWithBots set any open slots are taken by bots. It returns the
+// teams, the parties left waiting and whether two teams were formed.
+func formTeams(waiting [][]*Player, fillWithBots bool) ([]*Player, []*Player, [][]*Player, bool) {
+	teams := [2][]*Player{}
+	used := make([]bool, len(waiting))
+	for _, duos := range []bool{true, false} {
+		for i, party := range waiting {
+			if used[i] || (len(party) == teamSize) != duos {
+				continue
+			}
+			for t := range teams {
+				if len(teams[t])+len(party) <= teamSize {
+					teams[t] = append(teams[t], party...)
+					used[i] = true
+					break
+				}
+			}
+		}
+	}
+
+	full := len(teams[0]) == teamSize && len(teams[1]) == teamSize
+	if !full && !fillWithBots {
+		return nil, nil, waiting, false
+	}
+	bots := 0
+	for t := range teams {
+		for len(teams[t]) < teamSize {
+			bots++
+			teams[t] = append(teams[t], newBot(bots))
+		}
+	}
+
+	var left [][]*Player
+	for i, party := range waiting {
+		if !used[i] {
+			left = append(left, party)
+		}
+	}
+	return teams[0], teams[1], left, true
+}
+
+// countTeamWaiting records how many players are waiting in a team queue.
+func countTeamWaiting(opts GameOptions, waiting [][]*Player) {
+	n := 0
+	for _, party := range waiting {
+		n += len(party)
+	}
+	queuesMutex.Lock()
+	teamWaiting[opts] = n
+	queuesMutex.Unlock()
+}
+
+// teamLabel names a team after its players.
+func teamLabel(team []*Player) string {
+	return team[0].Username + " & " + team[1].Username
+}
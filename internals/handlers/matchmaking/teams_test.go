@@ -0,0 +1,113 @@
+package matchmaking
+
+import (
+	"strings"
+	"testing"
+)
+
+// parties builds the waiting parties from names like "a" for a solo
+// player and "b+c" for a premade duo.
+func parties(spec ...string) [][]*Player {
+	var waiting [][]*Player
+	for _, s := range spec {
+		var party []*Player
+		for _, name := range strings.Split(s, "+") {
+			party = append(party, &Player{Username: name})
+		}
+		waiting = append(waiting, party)
+	}
+	return waiting
+}
+
+// names writes players as "a+b", the way parties reads them.
+func names(players []*Player) string {
+	list := make([]string, len(players))
+	for i, p := range players {
+		list[i] = p.Username
+	}
+	return strings.Join(list, "+")
+}
+
+func TestFormTeams(t *testing.T) {
+	tests := []struct {
+		name         string
+		waiting      []string
+		fillWithBots bool
+		wantOK       bool
+		wantA, wantB string
+		wantLeft     []string
+	}{
+		{
+			name:    "four solo players",
+			waiting: []string{"a", "b", "c", "d"},
+			wantOK:  true,
+			wantA:   "a+b",
+			wantB:   "c+d",
+		},
+		{
+			name:    "two duos",
+			waiting: []string{"a+b", "c+d"},
+			wantOK:  true,
+			wantA:   "a+b",
+			wantB:   "c+d",
+		},
+		{
+			name:     "a late duo is not split and the youngest solo waits",
+			waiting:  []string{"a", "b", "c", "d+e"},
+			wantOK:   true,
+			wantA:    "d+e",
+			wantB:    "a+b",
+			wantLeft: []string{"c"},
+		},
+		{
+			name:     "two duos go before solo players",
+			waiting:  []string{"a", "b", "c+d", "e+f"},
+			wantOK:   true,
+			wantA:    "c+d",
+			wantB:    "e+f",
+			wantLeft: []string{"a", "b"},
+		},
+		{
+			name:     "too few players keep waiting",
+			waiting:  []string{"a", "b+c"},
+			wantLeft: []string{"a", "b+c"},
+		},
+		{
+			name:         "bots fill the open seats",
+			waiting:      []string{"a", "b+c"},
+			fillWithBots: true,
+			wantOK:       true,
+			wantA:        "b+c",
+			wantB:        "a+Bot",
+		},
+		{
+			name:         "a lone player gets a bot partner and bot opponents",
+			waiting:      []string{"a"},
+			fillWithBots: true,
+			wantOK:       true,
+			wantA:        "a+Bot",
+			wantB:        "Bot2+Bot3",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			teamA, teamB, left, ok := formTeams(parties(tt.waiting...), tt.fillWithBots)
+			if ok != tt.wantOK {
+				t.Fatalf("ok = %v, want %v", ok, tt.wantOK)
+			}
+			if got := names(teamA); got != tt.wantA {
+				t.Errorf("team A = %q, want %q", got, tt.wantA)
+			}
+			if got := names(teamB); got != tt.wantB {
+				t.Errorf("team B = %q, want %q", got, tt.wantB)
+			}
+			var gotLeft []string
+			for _, party := range left {
+				gotLeft = append(gotLeft, names(party))
+			}
+			if strings.Join(gotLeft, " ") != strings.Join(tt.wantLeft, " ") {
+				t.Errorf("left waiting = %v, want %v", gotLeft, tt.wantLeft)
+			}
+		})
+	}
+}
@@ -0,0 +1,1020 @@
+package matchmaking
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"Connect-4/internals/handlers/game"
+)
+
+const (
+	formatRoundRobin = "round_robin"
+	formatSwiss      = "swiss"
+	formatKnockout   = "knockout"
+
+	tournamentRegistering = "registering"
+	tournamentRunning     = "running"
+	tournamentFinished    = "finished"
+	tournamentCancelled   = "cancelled"
+
+	// Most players a tournament takes
+	maxTournamentPlayers = 64
+
+	// When a tournament starts if its creator does not say
+	tournamentDelay = 5 * time.Minute
+
+	// Pause between the rounds of a tournament
+	roundPause = 10 * time.Second
+
+	// Drawn knockout games are replayed with the colours swapped this many
+	// times before the higher seed goes through
+	knockoutReplays = 2
+)
+
+var (
+	// Tournaments that are not over yet, by id
+	tournaments      = make(map[int64]*Tournament)
+	tournamentsMutex sync.Mutex // To protect tournaments and everything in them
+
+	// How long a player may keep their opponent waiting at the start of a
+	// round before they lose the game by forfeit
+	tournamentForfeit = 2 * time.Minute
+)
+
+// Tournament is a tournament as returned by the API.
+type Tournament struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	Format    string    `json:"format"` // round_robin, swiss or knockout
+	Settings  QueueInfo `json:"settings"`
+	Rounds    int       `json:"rounds"` // set when the tournament starts, unless the creator chose it
+	Round     int       `json:"round"`  // the round being played, 0 before the start
+	Status    string    `json:"status"`
+	CreatedBy string    `json:"created_by"`
+	Starts    time.Time `json:"starts_at"`
+	Winner    string    `json:"winner,omitempty"`
+	Players   []string  `json:"players"` // by seed once the tournament has started
+
+	opts     GameOptions
+	entrants map[string]*entrant
+	pairings []*Pairing // every round so far
+	deadline time.Time  // players who are not there by then forfeit their game of the round
+	waiting  bool       // every game of the round is decided and the next one is coming
+}
+
+// entrant is a player registered in a tournament.
+type entrant struct {
+	rating    float64 // when the tournament started, or when they registered until then
+	seed      int     // 1 for the highest rated player
+	withdrawn bool
+	player    *Player // the player's /ws/game connection, nil while they are away
+}
+
+// present returns the connection of a player who is there to play.
+func (e *entrant) present() *Player {
+	if e.player == nil || e.withdrawn || !e.player.connected() || e.player.hasLeft() {
+		return nil
+	}
+	return e.player
+}
+
+// createTournament sets a tournament up and opens registration until it
+// starts. Tournament games are one against one with the given settings.
+func createTournament(creator, name, format string, rounds int, starts time.Time, opts GameOptions) (*Tournament, error) {
+	switch format {
+	case formatSwiss:
+	case formatRoundRobin, formatKnockout:
+		if rounds != 0 {
+			return nil, errors.New("rounds can only be chosen for Swiss tournaments")
+		}
+	default:
+		return nil, fmt.Errorf("format must be %s, %s or %s", formatRoundRobin, formatSwiss, formatKnockout)
+	}
+	if opts.Seats != minSeats || opts.Teams || opts.BestOf > 0 {
+		return nil, errors.New("tournament games are single games between two players")
+	}
+	if opts.Position != "" {
+		return nil, errors.New("tournament games start from the empty board")
+	}
+	if starts.IsZero() {
+		starts = time.Now().Add(tournamentDelay)
+	} else if starts.Before(time.Now()) {
+		return nil, errors.New("the start time has already passed")
+	}
+	if name == "" {
+		name = fmt.Sprintf("%s's %s tournament", creator, strings.ReplaceAll(format, "_", " "))
+	}
+
+	t := &Tournament{
+		Name:      name,
+		Format:    format,
+		Settings:  queueInfo(opts),
+		Rounds:    rounds,
+		Status:    tournamentRegistering,
+		CreatedBy: creator,
+		Starts:    starts.Truncate(time.Second),
+		Players:   []string{},
+		opts:      opts,
+		entrants:  make(map[string]*entrant),
+	}
+	if err := saveTournament(t); err != nil {
+		return nil, err
+	}
+	tournamentsMutex.Lock()
+	tournaments[t.ID] = t
+	tournamentsMutex.Unlock()
+	time.AfterFunc(time.Until(starts), t.start)
+	log.Printf("%s created tournament %d (%s), starting %s.", creator, t.ID, format, t.Starts.Format(time.RFC3339))
+	return t, nil
+}
+
+// findTournament returns the tournament with the given id if it is not
+// over yet.
+func findTournament(id string) *Tournament {
+	n, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return nil
+	}
+	tournamentsMutex.Lock()
+	defer tournamentsMutex.Unlock()
+	return tournaments[n]
+}
+
+// tournamentFromRequest returns the tournament a player connecting to
+// /ws/game plays in, e.g. tournament=3, or nil when they did not ask for one.
+func tournamentFromRequest(username string, r *http.Request) (*Tournament, error) {
+	id := r.URL.Query().Get("tournament")
+	if id == "" {
+		return nil, nil
+	}
+	t := findTournament(id)
+	if t == nil {
+		return nil, errors.New("that tournament is over or does not exist")
+	}
+	tournamentsMutex.Lock()
+	defer tournamentsMutex.Unlock()
+	if e := t.entrants[username]; e == nil || e.withdrawn {
+		return nil, errors.New("you are not registered in that tournament")
+	}
+	return t, nil
+}
+
+// view is a copy of the tournament to hand out. Must be called with
+// tournamentsMutex held.
+func (t *Tournament) view() Tournament {
+	v := *t
+	v.Players = append([]string{}, t.Players...)
+	return v
+}
+
+// send writes a message to a registered player if they are connected.
+// Must be called with tournamentsMutex held.
+func (t *Tournament) send(name string, msg map[string]interface{}) {
+	if e := t.entrants[name]; e != nil && e.player != nil {
+		e.player.Send(msg)
+	}
+}
+
+// broadcast writes a message to every connected player. Must be called
+// with tournamentsMutex held.
+func (t *Tournament) broadcast(msg map[string]interface{}) {
+	for _, name := range t.Players {
+		t.send(name, msg)
+	}
+}
+
+// register adds a player to a tournament that has not started yet.
+func (t *Tournament) register(username string) error {
+	tournamentsMutex.Lock()
+	defer tournamentsMutex.Unlock()
+	switch {
+	case t.Status != tournamentRegistering:
+		return errors.New("registration is closed")
+	case t.entrants[username] != nil:
+		return errors.New("you are already registered")
+	case len(t.entrants) >= maxTournamentPlayers:
+		return fmt.Errorf("the tournament is full (%d players)", maxTournamentPlayers)
+	}
+	t.entrants[username] = &entrant{rating: math.Round(GetRating(username).Rating)}
+	t.Players = append(t.Players, username)
+	saveEntrant(t, username)
+	log.Printf("%s registered for tournament %d.", username, t.ID)
+	return nil
+}
+
+// withdraw takes a player out of a tournament. Before the start they are
+// simply unregistered. Later every game of theirs still to come is lost by
+// forfeit, or left out of the pairings in a Swiss tournament; a game being
+// played is played out.
+func (t *Tournament) withdraw(username string) error {
+	tournamentsMutex.Lock()
+	defer tournamentsMutex.Unlock()
+	e := t.entrants[username]
+	if e == nil || e.withdrawn {
+		return errors.New("you are not registered")
+	}
+	switch t.Status {
+	case tournamentRegistering:
+		delete(t.entrants, username)
+		for i, name := range t.Players {
+			if name == username {
+				t.Players = append(t.Players[:i], t.Players[i+1:]...)
+				break
+			}
+		}
+		deleteEntrant(t, username)
+	case tournamentRunning:
+		e.withdrawn = true
+		saveEntrant(t, username)
+		if pr := t.current(username); pr != nil {
+			t.tryStart(pr)
+		}
+	default:
+		return errors.New("the tournament is over")
+	}
+	log.Printf("%s withdrew from tournament %d.", username, t.ID)
+	return nil
+}
+
+// current returns the undecided pairing of the player in the round being
+// played. Must be called with tournamentsMutex held.
+func (t *Tournament) current(name string) *Pairing {
+	for _, pr := range t.roundPairings(t.Round) {
+		if pr.Result == "" && (pr.Player1 == name || pr.Player2 == name) {
+			return pr
+		}
+	}
+	return nil
+}
+
+// attach connects a registered player's /ws/game socket to the tournament.
+// Their games start on it from now on, the first one right away if their
+// opponent is waiting.
+func (t *Tournament) attach(p *Player) {
+	tournamentsMutex.Lock()
+	defer tournamentsMutex.Unlock()
+	e := t.entrants[p.Username]
+	if e == nil || e.withdrawn || (t.Status != tournamentRegistering && t.Status != tournamentRunning) {
+		p.Send(map[string]interface{}{"type": "TOURNAMENT_CLOSED", "message": "The tournament is over."})
+		p.Conn.Close()
+		return
+	}
+	e.player = p
+	p.writeMu.Lock()
+	p.tournament = t
+	p.writeMu.Unlock()
+
+	msg := map[string]interface{}{
+		"type":       "TOURNAMENT_JOINED",
+		"message":    fmt.Sprintf("Waiting for %s to start.", t.Name),
+		"tournament": t.view(),
+	}
+	pr := t.current(p.Username)
+	if pr != nil {
+		msg["message"] = fmt.Sprintf("Round %d: your game starts as soon as %s is here.", t.Round, pr.opponent(p.Username))
+		msg["pairing"] = *pr
+	} else if t.Status == tournamentRunning {
+		msg["message"] = "Waiting for the next round."
+	}
+	p.Send(msg)
+	if pr != nil {
+		t.tryStart(pr)
+	}
+}
+
+// detach forgets a player's socket once they leave. They stay registered
+// and can connect again.
+func (t *Tournament) detach(p *Player) {
+	tournamentsMutex.Lock()
+	defer tournamentsMutex.Unlock()
+	if e := t.entrants[p.Username]; e != nil && e.player == p {
+		e.player = nil
+	}
+}
+
+// start closes registration and plays the first round, or calls the
+// tournament off if fewer than two players registered. Players are seeded
+// by rating.
+func (t *Tournament) start() {
+	tournamentsMutex.Lock()
+	defer tournamentsMutex.Unlock()
+	if t.Status != tournamentRegistering {
+		return
+	}
+	n := len(t.Players)
+	if n < 2 {
+		t.Status = tournamentCancelled
+		updateTournament(t)
+		delete(tournaments, t.ID)
+		log.Printf("Tournament %d cancelled: not enough players.", t.ID)
+		t.broadcast(map[string]interface{}{
+			"type":    "TOURNAMENT_CANCELLED",
+			"message": "The tournament was cancelled: not enough players registered.",
+		})
+		return
+	}
+
+	for _, name := range t.Players {
+		t.entrants[name].rating = math.Round(GetRating(name).Rating)
+	}
+	sort.SliceStable(t.Players, func(i, j int) bool {
+		ri, rj := t.entrants[t.Players[i]].rating, t.entrants[t.Players[j]].rating
+		if ri != rj {
+			return ri > rj
+		}
+		return t.Players[i] < t.Players[j]
+	})
+	for i, name := range t.Players {
+		t.entrants[name].seed = i + 1
+		saveEntrant(t, name)
+	}
+
+	// Everybody meets everybody once at most
+	most := n - 1 + n%2
+	switch t.Format {
+	case formatRoundRobin:
+		t.Rounds = most
+	case formatKnockout:
+		t.Rounds = 0
+		for size := 1; size < n; size *= 2 {
+			t.Rounds++
+		}
+	case formatSwiss:
+		if t.Rounds == 0 {
+			t.Rounds = 1
+			for size := 1; size < n; size *= 2 {
+				t.Rounds++
+			}
+		}
+		if t.Rounds > most {
+			t.Rounds = most
+		}
+	}
+	t.Status = tournamentRunning
+	log.Printf("Tournament %d started with %d players over %d rounds.", t.ID, n, t.Rounds)
+	t.nextRound()
+}
+
+// nextRound pairs the next round, tells every player who they play and
+// starts the games whose players are both there. Must be called with
+// tournamentsMutex held.
+func (t *Tournament) nextRound() {
+	t.Round++
+	t.waiting = false
+	var pairs []*Pairing
+	switch t.Format {
+	case formatRoundRobin:
+		pairs = t.roundRobinPairings(t.Round)
+	case formatSwiss:
+		pairs = t.swissPairings()
+	case formatKnockout:
+		pairs = t.knockoutPairings()
+	}
+	t.deadline = time.Now().Add(tournamentForfeit)
+	for i, pr := range pairs {
+		pr.Round, pr.Board, pr.Games, pr.t = t.Round, i+1, []int64{}, t
+		savePairing(pr)
+	}
+	t.pairings = append(t.pairings, pairs...)
+	updateTournament(t)
+	log.Printf("Tournament %d: round %d of %d paired.", t.ID, t.Round, t.Rounds)
+
+	for _, pr := range pairs {
+		for _, name := range []string{pr.Player1, pr.Player2} {
+			message := fmt.Sprintf("Round %d: you have a bye.", t.Round)
+			switch {
+			case pr.Player2 == "":
+			case name == pr.Player1:
+				message = fmt.Sprintf("Round %d: you play %s and move first.", t.Round, pr.Player2)
+			default:
+				message = fmt.Sprintf("Round %d: you play %s, who moves first.", t.Round, pr.Player1)
+			}
+			t.send(name, map[string]interface{}{
+				"type":     "ROUND_START",
+				"message":  message,
+				"round":    t.Round,
+				"pairing":  *pr,
+				"deadline": t.deadline,
+			})
+		}
+	}
+	for _, pr := range pairs {
+		if pr.Player2 == "" {
+			t.decide(pr, "bye", false)
+		} else {
+			t.tryStart(pr)
+		}
+	}
+
+	round := t.Round
+	time.AfterFunc(tournamentForfeit, func() {
+		tournamentsMutex.Lock()
+		defer tournamentsMutex.Unlock()
+		if t.Round == round {
+			for _, pr := range t.roundPairings(round) {
+				t.tryStart(pr)
+			}
+		}
+	})
+}
+
+// tryStart starts the game of a pairing once both players are there. After
+// the round's deadline, or once a player withdrew, the pairing is decided
+// by forfeit instead. Must be called with tournamentsMutex held.
+func (t *Tournament) tryStart(pr *Pairing) {
+	if pr.Result != "" || pr.session != nil || t.Status != tournamentRunning {
+		return
+	}
+	e1, e2 := t.entrants[pr.Player1], t.entrants[pr.Player2]
+	p1, p2 := e1.present(), e2.present()
+	if p1 == nil || p2 == nil {
+		if e1.withdrawn || e2.withdrawn || !time.Now().Before(t.deadline) {
+			t.forfeit(pr, p1 != nil, p2 != nil)
+		}
+		return
+	}
+
+	// The players' connections are already read by readMoves
+	players := []*Player{p1, p2}
+	names := []string{pr.Player1, pr.Player2}
+	for i, p := range players {
+		p.ID = i + 1
+	}
+	id := newGameID(names[0])
+	s := &GameSession{
+		Game:           newGame(id, names, t.opts),
+		Players:        players,
+		readersRunning: true,
+		rematchClosed:  true, // the pairings decide who plays whom
+		pairing:        pr,
+	}
+	pr.session, pr.GameID = s, id
+	savePairing(pr)
+	log.Printf("Tournament %d, round %d: %s v %s is game %s.", t.ID, pr.Round, names[0], names[1], id)
+	go startSession(s)
+}
+
+// forfeit decides a pairing that cannot be played: whoever is there wins.
+// If neither is, both lose, except in a knockout where the higher seed
+// goes through. Must be called with tournamentsMutex held.
+func (t *Tournament) forfeit(pr *Pairing, there1, there2 bool) {
+	result := "0-0"
+	switch {
+	case there1 && !there2:
+		result = "1-0"
+	case there2 && !there1:
+		result = "0-1"
+	case t.Format == formatKnockout && t.entrants[pr.Player2].seed < t.entrants[pr.Player1].seed:
+		result = "0-1"
+	case t.Format == formatKnockout:
+		result = "1-0"
+	}
+	message := fmt.Sprintf("Neither %s nor %s showed up; both lose by forfeit.", pr.Player1, pr.Player2)
+	switch {
+	case !there1 && !there2 && result != "0-0":
+		// Somebody has to go through a knockout, so the higher seed does
+		through := pr.Player1
+		if result == "0-1" {
+			through = pr.Player2
+		}
+		message = fmt.Sprintf("Neither %s nor %s showed up; %s goes through as the higher seed.", pr.Player1, pr.Player2, through)
+	case result == "1-0":
+		message = fmt.Sprintf("%s did not show up; %s wins by forfeit.", pr.Player2, pr.Player1)
+	case result == "0-1":
+		message = fmt.Sprintf("%s did not show up; %s wins by forfeit.", pr.Player1, pr.Player2)
+	}
+	log.Printf("Tournament %d, round %d: %s", t.ID, pr.Round, message)
+	view := *pr
+	view.Result, view.Forfeit = result, true
+	for _, name := range []string{pr.Player1, pr.Player2} {
+		t.send(name, map[string]interface{}{
+			"type":    "PAIRING_FORFEIT",
+			"message": message,
+			"pairing": view,
+		})
+	}
+	t.decide(pr, result, true)
+}
+
+// record takes the result of a finished tournament game. A drawn knockout
+// game is replayed with the colours swapped, and an aborted game is lost
+// by whoever did not make their first move.
+func (t *Tournament) record(pr *Pairing, s *GameSession, gameID int64) {
+	g := s.Game
+	g.Mutex.Lock()
+	state := g.State
+	places := append([]int{}, g.Places...)
+	g.Mutex.Unlock()
+
+	tournamentsMutex.Lock()
+	defer tournamentsMutex.Unlock()
+	pr.session, pr.GameID = nil, ""
+	if gameID != 0 {
+		pr.Games = append(pr.Games, gameID)
+	}
+	if state == game.StateAborted {
+		switch s.abortedBy {
+		case 1:
+			t.decide(pr, "0-1", true)
+		case 2:
+			t.decide(pr, "1-0", true)
+		default: // nobody to blame, so it is played again
+			savePairing(pr)
+			t.tryStart(pr)
+		}
+		return
+	}
+
+	result := "draw"
+	if places[0] < places[1] {
+		result = "1-0"
+	} else if places[0] > places[1] {
+		result = "0-1"
+	}
+	if result == "draw" && t.Format == formatKnockout && len(pr.Games) <= knockoutReplays {
+		pr.Player1, pr.Player2 = pr.Player2, pr.Player1
+		savePairing(pr)
+		msg := map[string]interface{}{
+			"type":    "KNOCKOUT_REPLAY",
+			"message": fmt.Sprintf("A knockout game cannot end in a draw. The replay, with %s moving first, starts in %d seconds.", pr.Player1, int(seriesPause.Seconds())),
+			"pairing": *pr,
+		}
+		t.send(pr.Player1, msg)
+		t.send(pr.Player2, msg)
+		time.AfterFunc(seriesPause, func() {
+			tournamentsMutex.Lock()
+			defer tournamentsMutex.Unlock()
+			t.tryStart(pr)
+		})
+		return
+	}
+	t.decide(pr, result, false)
+}
+
+// decide records the result of a pairing and moves the tournament on if
+// it was the last one of the round. Must be called with tournamentsMutex
+// held.
+func (t *Tournament) decide(pr *Pairing, result string, forfeit bool) {
+	pr.Result, pr.Forfeit = result, forfeit
+	pr.session, pr.GameID = nil, ""
+	savePairing(pr)
+	t.checkRound()
+}
+
+// checkRound ends the round once every pairing in it is decided, and then
+// either ends the tournament or pairs the next round after a pause. Must
+// be called with tournamentsMutex held.
+func (t *Tournament) checkRound() {
+	if t.waiting || t.Status != tournamentRunning {
+		return
+	}
+	for _, pr := range t.roundPairings(t.Round) {
+		if pr.Result == "" {
+			return
+		}
+	}
+	t.waiting = true
+	standings := t.standings()
+	if t.Round >= t.Rounds || (t.Format == formatSwiss && t.remaining() < 2) {
+		t.finish(standings)
+		return
+	}
+	t.broadcast(map[string]interface{}{
+		"type":      "ROUND_OVER",
+		"message":   fmt.Sprintf("Round %d is over. Round %d starts in %d seconds.", t.Round, t.Round+1, int(roundPause.Seconds())),
+		"round":     t.Round,
+		"standings": standings,
+	})
+	time.AfterFunc(roundPause, func() {
+		tournamentsMutex.Lock()
+		defer tournamentsMutex.Unlock()
+		if t.Status == tournamentRunning {
+			t.nextRound()
+		}
+	})
+}
+
+// remaining counts the players who have not withdrawn.
+func (t *Tournament) remaining() int {
+	n := 0
+	for _, e := range t.entrants {
+		if !e.withdrawn {
+			n++
+		}
+	}
+	return n
+}
+
+// finish ends the tournament and tells everybody who won. Must be called
+// with tournamentsMutex held.
+func (t *Tournament) finish(standings []TournamentStanding) {
+	t.Status = tournamentFinished
+	if len(standings) > 0 {
+		t.Winner = standings[0].Username
+	}
+	updateTournament(t)
+	delete(tournaments, t.ID)
+	log.Printf("Tournament %d over, %s wins.", t.ID, t.Winner)
+	t.broadcast(map[string]interface{}{
+		"type":      "TOURNAMENT_OVER",
+		"message":   fmt.Sprintf("%s wins %s!", t.Winner, t.Name),
+		"winner":    t.Winner,
+		"standings": standings,
+	})
+}
+
+// saveTournament stores a new tournament and sets its id.
+func saveTournament(t *Tournament) error {
+	settings, _ := json.Marshal(t.Settings)
+	rankMutex.Lock()
+	defer rankMutex.Unlock()
+	res, err := db.Exec(`
+		INSERT INTO tournaments (name, format, settings, rounds, status, created_by, starts_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, t.Name, t.Format, string(settings), t.Rounds, t.Status, t.CreatedBy, t.Starts.Unix())
+	if err != nil {
+		log.Printf("Error saving tournament: %v", err)
+		return errors.New("the tournament could not be saved")
+	}
+	t.ID, _ = res.LastInsertId()
+	return nil
+}
+
+// updateTournament stores how far a tournament has got.
+func updateTournament(t *Tournament) {
+	rankMutex.Lock()
+	defer rankMutex.Unlock()
+	_, err := db.Exec(`
+		UPDATE tournaments SET rounds = ?, round = ?, status = ?, winner = NULLIF(?, ''),
+			finished_at = CASE WHEN ? IN ('finished', 'cancelled') THEN CURRENT_TIMESTAMP END
+		WHERE id = ?
+	`, t.Rounds, t.Round, t.Status, t.Winner, t.Status, t.ID)
+	if err != nil {
+		log.Printf("Error updating tournament %d: %v", t.ID, err)
+	}
+}
+
+// saveEntrant stores a player's registration.
+func saveEntrant(t *Tournament, name string) {
+	e := t.entrants[name]
+	rankMutex.Lock()
+	defer rankMutex.Unlock()
+	_, err := db.Exec(`
+		INSERT INTO tournament_players (tournament_id, username, rating, withdrawn) VALUES (?, ?, ?, ?)
+		ON CONFLICT(tournament_id, username) DO UPDATE SET rating = excluded.rating, withdrawn = excluded.withdrawn
+	`, t.ID, name, e.rating, e.withdrawn)
+	if err != nil {
+		log.Printf("Error saving %s in tournament %d: %v", name, t.ID, err)
+	}
+}
+
+// deleteEntrant removes a player's registration.
+func deleteEntrant(t *Tournament, name string) {
+	rankMutex.Lock()
+	defer rankMutex.Unlock()
+	_, err := db.Exec(`DELETE FROM tournament_players WHERE tournament_id = ? AND username = ?`, t.ID, name)
+	if err != nil {
+		log.Printf("Error removing %s from tournament %d: %v", name, t.ID, err)
+	}
+}
+
+// savePairing stores a pairing, or how it has changed since.
+func savePairing(pr *Pairing) {
+	ids := make([]string, len(pr.Games))
+	for i, id := range pr.Games {
+		ids[i] = strconv.FormatInt(id, 10)
+	}
+	games := strings.Join(ids, ",")
+	rankMutex.Lock()
+	defer rankMutex.Unlock()
+	if pr.id == 0 {
+		res, err := db.Exec(`
+			INSERT INTO tournament_pairings (tournament_id, round, board, player1, player2, result, forfeit, games, live_game)
+			VALUES (?, ?, ?, ?, NULLIF(?, ''), NULLIF(?, ''), ?, ?, NULLIF(?, ''))
+		`, pr.t.ID, pr.Round, pr.Board, pr.Player1, pr.Player2, pr.Result, pr.Forfeit, games, pr.GameID)
+		if err != nil {
+			log.Printf("Error saving pairing: %v", err)
+			return
+		}
+		pr.id, _ = res.LastInsertId()
+		return
+	}
+	_, err := db.Exec(`
+		UPDATE tournament_pairings SET player1 = ?, player2 = NULLIF(?, ''), result = NULLIF(?, ''), forfeit = ?,
+			games = ?, live_game = NULLIF(?, '')
+		WHERE id = ?
+	`, pr.Player1, pr.Player2, pr.Result, pr.Forfeit, games, pr.GameID, pr.id)
+	if err != nil {
+		log.Printf("Error updating pairing %d: %v", pr.id, err)
+	}
+}
+
+// scanTournament reads a tournament row without its players.
+func scanTournament(row interface{ Scan(...interface{}) error }) (*Tournament, error) {
+	t := &Tournament{Players: []string{}, entrants: make(map[string]*entrant)}
+	var settings string
+	var starts int64
+	err := row.Scan(&t.ID, &t.Name, &t.Format, &settings, &t.Rounds, &t.Round, &t.Status, &t.CreatedBy, &starts, &t.Winner)
+	if err != nil {
+		return nil, err
+	}
+	json.Unmarshal([]byte(settings), &t.Settings)
+	t.Starts = time.Unix(starts, 0)
+	return t, nil
+}
+
+// loadPlayers reads the players of a tournament, by seed. Must be called
+// with rankMutex held.
+func (t *Tournament) loadPlayers() {
+	rows, err := db.Query(`
+		SELECT username, rating, withdrawn FROM tournament_players
+		WHERE tournament_id = ? ORDER BY rating DESC, username
+	`, t.ID)
+	if err != nil {
+		log.Printf("Error fetching players of tournament %d: %v", t.ID, err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		e := &entrant{}
+		if err := rows.Scan(&name, &e.rating, &e.withdrawn); err != nil {
+			log.Println("Error scanning row:", err)
+			continue
+		}
+		t.Players = append(t.Players, name)
+		e.seed = len(t.Players)
+		t.entrants[name] = e
+	}
+}
+
+const tournamentColumns = `id, name, format, settings, rounds, round, status, created_by, starts_at, IFNULL(winner, '')`
+
+// loadTournament reads a tournament with its players and every pairing so
+// far back from the database.
+func loadTournament(id int64) (*Tournament, error) {
+	rankMutex.Lock()
+	defer rankMutex.Unlock()
+	t, err := scanTournament(db.QueryRow(`SELECT `+tournamentColumns+` FROM tournaments WHERE id = ?`, id))
+	if err != nil {
+		return nil, err
+	}
+	t.loadPlayers()
+
+	rows, err := db.Query(`
+		SELECT round, board, player1, IFNULL(player2, ''), IFNULL(result, ''), forfeit, IFNULL(games, ''), IFNULL(live_game, '')
+		FROM tournament_pairings WHERE tournament_id = ? ORDER BY round, board
+	`, id)
+	if err != nil {
+		log.Printf("Error fetching pairings of tournament %d: %v", id, err)
+		return t, nil
+	}
+	defer rows.Close()
+	for rows.Next() {
+		pr := &Pairing{Games: []int64{}}
+		var games string
+		if err := rows.Scan(&pr.Round, &pr.Board, &pr.Player1, &pr.Player2, &pr.Result, &pr.Forfeit, &games, &pr.GameID); err != nil {
+			log.Println("Error scanning row:", err)
+			continue
+		}
+		for _, v := range strings.Split(games, ",") {
+			if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+				pr.Games = append(pr.Games, n)
+			}
+		}
+		t.pairings = append(t.pairings, pr)
+	}
+	return t, nil
+}
+
+// GetTournaments returns the most recent tournaments, optionally only
+// those with the given status.
+func GetTournaments(status string, limit int) []Tournament {
+	rankMutex.Lock()
+	defer rankMutex.Unlock()
+
+	rows, err := db.Query(`
+		SELECT `+tournamentColumns+` FROM tournaments
+		WHERE ? = '' OR status = ?
+		ORDER BY id DESC LIMIT ?
+	`, status, status, limit)
+	if err != nil {
+		log.Printf("Error fetching tournaments: %v", err)
+		return nil
+	}
+	var list []*Tournament
+	for rows.Next() {
+		t, err := scanTournament(rows)
+		if err != nil {
+			log.Println("Error scanning row:", err)
+			continue
+		}
+		list = append(list, t)
+	}
+	rows.Close()
+
+	result := make([]Tournament, 0, len(list))
+	for _, t := range list {
+		t.loadPlayers()
+		result = append(result, *t)
+	}
+	return result
+}
+
+// ResumeTournaments picks up the tournaments of the previous run when the
+// server starts. Those still taking registrations start as planned; those
+// being played are cancelled, since their games ended with the server.
+func ResumeTournaments() {
+	rankMutex.Lock()
+	rows, err := db.Query(`SELECT id FROM tournaments WHERE status IN (?, ?)`, tournamentRegistering, tournamentRunning)
+	if err != nil {
+		rankMutex.Unlock()
+		log.Printf("Error fetching unfinished tournaments: %v", err)
+		return
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			log.Println("Error scanning row:", err)
+			continue
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	rankMutex.Unlock()
+
+	for _, id := range ids {
+		t, err := loadTournament(id)
+		if err != nil {
+			log.Printf("Error fetching tournament %d: %v", id, err)
+			continue
+		}
+		if t.Status == tournamentRunning {
+			t.Status = tournamentCancelled
+			updateTournament(t)
+			log.Printf("Tournament %d cancelled: the server stopped during round %d.", t.ID, t.Round)
+			continue
+		}
+		t.opts = queueOptions(t.Settings)
+		tournamentsMutex.Lock()
+		tournaments[t.ID] = t
+		tournamentsMutex.Unlock()
+		time.AfterFunc(time.Until(t.Starts), t.start)
+		log.Printf("Tournament %d resumed, starting %s.", t.ID, t.Starts.Format(time.RFC3339))
+	}
+}
+
+// HandleTournaments lists tournaments (GET), newest first, e.g.
+// /api/tournaments?status=registering&limit=20, or creates one (POST) with
+// the same game settings as /ws/game, e.g. POST /api/tournaments?username=alice
+// &format=swiss&rounds=5&time=3%2B2&start=2026-05-01T18:00:00Z. Anybody
+// can create a tournament; it starts in five minutes unless start is given.
+func HandleTournaments(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if r.Method != http.MethodPost {
+		limit := 50
+		if v := q.Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(GetTournaments(q.Get("status"), limit))
+		return
+	}
+
+	username := q.Get("username")
+	if username == "" {
+		http.Error(w, "Username required", http.StatusBadRequest)
+		return
+	}
+	opts, err := parseGameOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rounds := 0
+	if v := q.Get("rounds"); v != "" {
+		rounds, err = strconv.Atoi(v)
+		if err != nil || rounds < 1 {
+			http.Error(w, "rounds must be a positive number", http.StatusBadRequest)
+			return
+		}
+	}
+	var starts time.Time
+	if v := q.Get("start"); v != "" {
+		starts, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			http.Error(w, "start must be a time like 2026-05-01T18:00:00Z", http.StatusBadRequest)
+			return
+		}
+	}
+	t, err := createTournament(username, q.Get("name"), q.Get("format"), rounds, starts, opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	tournamentsMutex.Lock()
+	view := t.view()
+	tournamentsMutex.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(view)
+}
+
+// HandleTournamentEntry registers a player in a tournament or withdraws
+// them, e.g. POST /api/tournaments/join?id=3&username=bob. Registered
+// players connect to /ws/game with tournament=<id> to get their games.
+func HandleTournamentEntry(join bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "POST required", http.StatusMethodNotAllowed)
+			return
+		}
+		username := r.URL.Query().Get("username")
+		t := findTournament(r.URL.Query().Get("id"))
+		if username == "" || t == nil {
+			http.Error(w, "Unknown tournament", http.StatusNotFound)
+			return
+		}
+		var err error
+		if join {
+			err = t.register(username)
+		} else {
+			err = t.withdraw(username)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// tournamentFromQuery loads the tournament named by the id parameter,
+// writing the error response if there is none.
+func tournamentFromQuery(w http.ResponseWriter, r *http.Request) *Tournament {
+	id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Unknown tournament", http.StatusNotFound)
+		return nil
+	}
+	t, err := loadTournament(id)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Error fetching tournament %d: %v", id, err)
+		}
+		http.Error(w, "Unknown tournament", http.StatusNotFound)
+		return nil
+	}
+	return t
+}
+
+// HandleTournamentStandings returns the standings of a tournament with
+// their tiebreaks, e.g. /api/tournaments/standings?id=3
+func HandleTournamentStandings(w http.ResponseWriter, r *http.Request) {
+	t := tournamentFromQuery(w, r)
+	if t == nil {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"tournament": t,
+		"standings":  t.standings(),
+	})
+}
+
+// HandleTournamentBracket returns the pairings of every round so far with
+// their results, e.g. /api/tournaments/bracket?id=3. A pairing being played
+// has the id of its game, which can be watched on /ws/watch.
+func HandleTournamentBracket(w http.ResponseWriter, r *http.Request) {
+	t := tournamentFromQuery(w, r)
+	if t == nil {
+		return
+	}
+	rounds := make([][]Pairing, t.Round)
+	for _, pr := range t.pairings {
+		if pr.Round >= 1 && pr.Round <= len(rounds) {
+			rounds[pr.Round-1] = append(rounds[pr.Round-1], *pr)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"tournament": t,
+		"rounds":     rounds,
+	})
+}
@@ -52,7 +52,7 @@ func SignupHandler(db *sql.DB) http.HandlerFunc {
 			return
 		}
 		// inserting into rankings table
-		_, err = db.Exec(`INSERT OR IGNORE INTO rankings (username, score) VALUES (?, 0)`, req.Username)
+		_, err = db.Exec(`INSERT OR IGNORE INTO rankings (username) VALUES (?)`, req.Username)
 		if err != nil {
 			log.Printf("Failed to insert into rankings: %v", err)
 		}